@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// anomalyDetectors maps the --anomaly methods to functions returning the
+// expected center of the last value and its spread, from the values of the
+// trailing window before it.
+var anomalyDetectors = map[string]func(window []float64) (float64, float64){
+	"zscore": zscoreBand,
+	"ewma":   ewmaBand,
+	"mad":    madBand,
+}
+
+// minAnomalyWindow is the number of values required before the last value
+// is scored. Shorter series are never anomalous.
+const minAnomalyWindow = 3
+
+// anomalyResult is the score of the last value of the --series-path series,
+// available to eval statements and output templates as anomaly.
+type anomalyResult struct {
+	method    string
+	value     float64
+	center    float64
+	spread    float64
+	score     float64
+	points    int
+	anomalous bool
+}
+
+// vars returns the result as a sandbox and template object.
+func (r anomalyResult) vars() map[string]interface{} {
+	lower, upper := r.center-plugin.Sensitivity*r.spread, r.center+plugin.Sensitivity*r.spread
+	return map[string]interface{}{
+		"method":      r.method,
+		"value":       r.value,
+		"center":      r.center,
+		"spread":      r.spread,
+		"lower":       lower,
+		"upper":       upper,
+		"score":       r.score,
+		"sensitivity": plugin.Sensitivity,
+		"points":      r.points,
+		"anomalous":   r.anomalous,
+	}
+}
+
+// message returns the check output of an anomalous value.
+func (r anomalyResult) message() string {
+	return fmt.Sprintf("Anomaly detected (%s): %v is outside of the expected range %.4g to %.4g (score %.2f, sensitivity %v, %d points)",
+		r.method, r.value, r.center-plugin.Sensitivity*r.spread, r.center+plugin.Sensitivity*r.spread, r.score, plugin.Sensitivity, r.points)
+}
+
+// seriesExpression returns the Javascript expression of the --series-path.
+// Paths are relative to result, unless they start with an other sandbox
+// variable (e.g. results, baseline or series).
+func seriesExpression(path string) string {
+	for _, name := range []string{"result", "baseline", "series"} {
+		if strings.HasPrefix(path, name) {
+			return path
+		}
+	}
+	if strings.HasPrefix(path, "[") {
+		return "result" + path
+	}
+	return "result." + path
+}
+
+// seriesPoint is a value of the --series-path series and its timestamp in
+// seconds.
+type seriesPoint struct {
+	t float64
+	v float64
+}
+
+// seriesPoints returns the points of the --series-path series, as parsed by
+// the stats helpers.
+func seriesPoints(vm evaluator) ([]seriesPoint, error) {
+	value, err := vm.Run(fmt.Sprintf("stats.points(%s)", seriesExpression(plugin.SeriesPath)))
+	if err != nil {
+		return nil, fmt.Errorf("--series-path %s: %w", plugin.SeriesPath, err)
+	}
+	exported, ok := value.Export().([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("--series-path %s is not an array", plugin.SeriesPath)
+	}
+	points := []seriesPoint{}
+	for _, item := range exported {
+		point, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		t, tok := exportedNumber(point["t"])
+		v, vok := exportedNumber(point["v"])
+		if tok && vok {
+			points = append(points, seriesPoint{t: t, v: v})
+		}
+	}
+	return points, nil
+}
+
+// exportedNumber returns the value of an exported Javascript number.
+func exportedNumber(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// detectAnomaly scores the last value of the --series-path series against
+// the --anomaly-window values before it, and seeds the result into the
+// sandbox as anomaly.
+func detectAnomaly(vm evaluator) (anomalyResult, error) {
+	result := anomalyResult{method: plugin.Anomaly, score: math.NaN(), center: math.NaN(), spread: math.NaN(), value: math.NaN()}
+	points, err := seriesPoints(vm)
+	if err != nil {
+		return result, err
+	}
+	values := make([]float64, len(points))
+	for i, point := range points {
+		values[i] = point.v
+	}
+	if len(values) > 0 {
+		result.value = values[len(values)-1]
+		window := values[:len(values)-1]
+		if plugin.AnomalyWindow > 0 && len(window) > plugin.AnomalyWindow {
+			window = window[len(window)-plugin.AnomalyWindow:]
+		}
+		result.points = len(window)
+		if len(window) >= minAnomalyWindow {
+			result.center, result.spread = anomalyDetectors[plugin.Anomaly](window)
+			result.score = anomalyScore(result.value, result.center, result.spread)
+			result.anomalous = math.Abs(result.score) > plugin.Sensitivity
+		}
+	}
+	if plugin.Debug {
+		fmt.Printf("Anomaly result: %v\n", result.vars())
+	}
+	return result, vm.Set("anomaly", result.vars())
+}
+
+// anomalyScore returns the number of spreads value is away from center. A
+// value other than center is infinitely far from a window without spread.
+func anomalyScore(value, center, spread float64) float64 {
+	if spread == 0 {
+		if value == center {
+			return 0
+		}
+		return math.Copysign(math.Inf(1), value-center)
+	}
+	return (value - center) / spread
+}
+
+// zscoreBand returns the mean and standard deviation of the window.
+func zscoreBand(window []float64) (float64, float64) {
+	mean := 0.0
+	for _, v := range window {
+		mean += v
+	}
+	mean /= float64(len(window))
+	variance := 0.0
+	for _, v := range window {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(window)))
+}
+
+// ewmaBand returns the exponentially weighted moving average and standard
+// deviation of the window, weighting recent values more with a smoothing
+// factor of 2 / (n + 1).
+func ewmaBand(window []float64) (float64, float64) {
+	alpha := 2 / (float64(len(window)) + 1)
+	mean, variance := window[0], 0.0
+	for _, v := range window[1:] {
+		diff := v - mean
+		increment := alpha * diff
+		mean += increment
+		variance = (1 - alpha) * (variance + diff*increment)
+	}
+	return mean, math.Sqrt(variance)
+}
+
+// madBand returns the median of the window and its median absolute
+// deviation, scaled to estimate the standard deviation of normal data so
+// the --sensitivity compares to the other methods.
+func madBand(window []float64) (float64, float64) {
+	center := median(window)
+	deviations := make([]float64, len(window))
+	for i, v := range window {
+		deviations[i] = math.Abs(v - center)
+	}
+	return center, 1.4826 * median(deviations)
+}
+
+// median returns the median of values, without reordering them.
+func median(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
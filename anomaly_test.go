@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAnomalyDetection(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("test")))
+	defer server.Close()
+
+	tests := []struct {
+		fixture        string
+		anomaly        string
+		anomaly_window int
+		evals          []string
+		status         int
+		output         string
+	}{
+		{"anomaly-steady.json", "zscore", 0, nil, sensu.CheckStateOK, "All eval conditions were met."},
+		{"anomaly-steady.json", "ewma", 0, nil, sensu.CheckStateOK, "All eval conditions were met."},
+		{"anomaly-steady.json", "mad", 0, nil, sensu.CheckStateOK, "All eval conditions were met."},
+		{"anomaly-spike.json", "zscore", 0, nil, sensu.CheckStateCritical, "Anomaly detected (zscore): 140 is outside of the expected range 94.31 to 105.7 (score 21.08, sensitivity 3, 10 points)"},
+		{"anomaly-spike.json", "ewma", 0, nil, sensu.CheckStateCritical, "Anomaly detected (ewma): 140 is outside of the expected range 94.34 to 105.4 (score 21.85, sensitivity 3, 10 points)"},
+		{"anomaly-spike.json", "mad", 0, nil, sensu.CheckStateCritical, "Anomaly detected (mad): 140 is outside of the expected range 91.1 to 108.9 (score 13.49, sensitivity 3, 10 points)"},
+		// an earlier outlier in the window inflates the standard deviation,
+		// but not the median absolute deviation
+		{"anomaly-outlier-window.json", "zscore", 0, nil, sensu.CheckStateOK, "All eval conditions were met."},
+		{"anomaly-outlier-window.json", "mad", 0, nil, sensu.CheckStateCritical, "Anomaly detected (mad): 115 is outside of the expected range 91.6 to 109.4 (score 4.89, sensitivity 3, 10 points)"},
+		{"anomaly-outlier-window.json", "zscore", 5, nil, sensu.CheckStateCritical, "Anomaly detected (zscore): 115 is outside of the expected range 93.16 to 106.8 (score 6.58, sensitivity 3, 5 points)"},
+		// eval statements run next to the detection, and can read its score
+		{"anomaly-steady.json", "zscore", 0, []string{"anomaly.score < 0"}, sensu.CheckStateCritical, `An eval condition was not met: "anomaly.score < 0" (false)`},
+		{"anomaly-spike.json", "zscore", 0, []string{"anomaly.points === 10"}, sensu.CheckStateCritical, "Anomaly detected (zscore): 140 is outside of the expected range 94.31 to 105.7 (score 21.08, sensitivity 3, 10 points)"},
+	}
+	for _, tt := range tests {
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.Url = server.URL + "/" + tt.fixture
+		plugin.EvalStatus = 2
+		plugin.Anomaly = tt.anomaly
+		plugin.SeriesPath = "data.result[0].values"
+		plugin.Sensitivity = 3
+		plugin.AnomalyWindow = tt.anomaly_window
+		plugin.EvalStatements = tt.evals
+		plugin.OutputTemplate = "{{ .check.output }}"
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			continue
+		}
+		vm, err := newSandbox(string(mustQuery(t, plugin.Url)), nil)
+		if err != nil {
+			t.Errorf("newSandbox() unexpected err: %v", err)
+			continue
+		}
+		status, output, err := evaluate(vm)
+		if err == nil {
+			output, err = renderOutput(vm, status, output)
+		}
+		if status != tt.status || output != tt.output || err != nil {
+			t.Errorf("evaluate() %s %s window %d status: %v output: %q err: %v", tt.fixture, tt.anomaly, tt.anomaly_window, status, output, err)
+		}
+		status, err = executeCheck(nil)
+		if status != tt.status || err != nil {
+			t.Errorf("executeCheck(nil) %s %s status: %v err: %v", tt.fixture, tt.anomaly, status, err)
+		}
+	}
+}
+
+func TestAnomalyBands(t *testing.T) {
+	window := []float64{2, 4, 4, 4, 5, 5, 7, 9}
+	tests := []struct {
+		name   string
+		band   func([]float64) (float64, float64)
+		center float64
+		spread float64
+	}{
+		{"zscore", zscoreBand, 5, 2},
+		{"mad", madBand, 4.5, 1.4826 * 0.5},
+		{"ewma", ewmaBand, 5.5243, 2.3907},
+	}
+	for _, tt := range tests {
+		center, spread := tt.band(window)
+		if math.Abs(center-tt.center) > 1e-4 || math.Abs(spread-tt.spread) > 1e-4 {
+			t.Errorf("%sBand() center: %v spread: %v", tt.name, center, spread)
+		}
+	}
+
+	scores := []struct {
+		value  float64
+		center float64
+		spread float64
+		score  float64
+	}{
+		{12, 10, 2, 1},
+		{10, 10, 0, 0},
+		{11, 10, 0, math.Inf(1)},
+		{9, 10, 0, math.Inf(-1)},
+	}
+	for _, tt := range scores {
+		if score := anomalyScore(tt.value, tt.center, tt.spread); score != tt.score {
+			t.Errorf("anomalyScore(%v, %v, %v) = %v", tt.value, tt.center, tt.spread, score)
+		}
+	}
+}
+
+func TestAnomalyArgs(t *testing.T) {
+	tests := []struct {
+		anomaly     string
+		series_path string
+		sensitivity float64
+		window      int
+		has_err     bool
+	}{
+		{"zscore", "data.result[0].values", 3, 0, false},
+		{"holt-winters", "data.result[0].values", 3, 0, true},
+		{"zscore", "", 3, 0, true},
+		{"mad", "data.result[0].values", 0, 0, true},
+		{"ewma", "data.result[0].values", 2.5, -1, true},
+	}
+	for _, tt := range tests {
+		plugin = Config{}
+		plugin.Url = "http://localhost"
+		plugin.EvalStatus = 1
+		plugin.Anomaly = tt.anomaly
+		plugin.SeriesPath = tt.series_path
+		plugin.Sensitivity = tt.sensitivity
+		plugin.AnomalyWindow = tt.window
+		if _, err := checkArgs(nil); (err != nil) != tt.has_err {
+			t.Errorf("checkArgs(nil) anomaly: %q series path: %q sensitivity: %v window: %v err: %v", tt.anomaly, tt.series_path, tt.sensitivity, tt.window, err)
+		}
+	}
+
+	paths := map[string]string{
+		"data.result[0].values":          "result.data.result[0].values",
+		"[0].values":                     "result[0].values",
+		"results.latency.data.result":    "results.latency.data.result",
+		"series.values":                  "series.values",
+		"baseline.data.result[0].values": "baseline.data.result[0].values",
+	}
+	for path, expected := range paths {
+		if expression := seriesExpression(path); expression != expected {
+			t.Errorf("seriesExpression(%q) = %q", path, expression)
+		}
+	}
+}
+
+// mustQuery returns the response of a GET request to url.
+func mustQuery(t *testing.T, url string) []byte {
+	data, err := doRequest(url, "GET", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
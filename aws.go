@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsCredentials are the AWS access keys used to sign requests.
+type awsCredentials struct {
+	accessKeyId     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// loadAwsCredentials returns the credentials of the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables, or else of the AWS_PROFILE
+// (default) profile of the shared credentials or config file.
+func loadAwsCredentials() (awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); len(id) > 0 && len(secret) > 0 {
+		return awsCredentials{accessKeyId: id, secretAccessKey: secret, sessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	profile := awsProfile()
+	for _, settings := range []map[string]string{
+		readAwsFile(awsFile("AWS_SHARED_CREDENTIALS_FILE", "credentials"), profile),
+		readAwsFile(awsFile("AWS_CONFIG_FILE", "config"), awsConfigSection(profile)),
+	} {
+		if len(settings["aws_access_key_id"]) > 0 && len(settings["aws_secret_access_key"]) > 0 {
+			return awsCredentials{
+				accessKeyId:     settings["aws_access_key_id"],
+				secretAccessKey: settings["aws_secret_access_key"],
+				sessionToken:    settings["aws_session_token"],
+			}, nil
+		}
+	}
+	return awsCredentials{}, fmt.Errorf("AWS credentials not found in the environment or the shared credentials file (profile %q)", profile)
+}
+
+// awsRegion returns the --region, or else the region of the AWS_REGION or
+// AWS_DEFAULT_REGION environment variables, or of the AWS profile.
+func awsRegion() string {
+	for _, region := range []string{plugin.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if len(region) > 0 {
+			return region
+		}
+	}
+	return readAwsFile(awsFile("AWS_CONFIG_FILE", "config"), awsConfigSection(awsProfile()))["region"]
+}
+
+// awsProfile returns the AWS_PROFILE, or the default profile.
+func awsProfile() string {
+	if profile := os.Getenv("AWS_PROFILE"); len(profile) > 0 {
+		return profile
+	}
+	return "default"
+}
+
+// awsConfigSection returns the config file section of a profile: named
+// profiles are prefixed with "profile ", unlike in the credentials file.
+func awsConfigSection(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+// awsFile returns the path of the environment variable, or else of the
+// named file in the ~/.aws directory.
+func awsFile(variable string, name string) string {
+	if path := os.Getenv(variable); len(path) > 0 {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aws", name)
+}
+
+// readAwsFile returns the settings of a section of an AWS shared config or
+// credentials file. Missing files have no settings.
+func readAwsFile(path string, section string) map[string]string {
+	settings := map[string]string{}
+	file, err := os.Open(path)
+	if err != nil {
+		return settings
+	}
+	defer file.Close()
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if keyValue := strings.SplitN(line, "=", 2); len(keyValue) == 2 {
+			settings[strings.TrimSpace(keyValue[0])] = strings.TrimSpace(keyValue[1])
+		}
+	}
+	return settings
+}
+
+// awsSigner signs requests with AWS Signature Version 4.
+type awsSigner struct {
+	credentials awsCredentials
+	region      string
+	service     string
+}
+
+// do sends the request with the body, signing its host, x-amz-date and
+// request headers.
+func (s awsSigner) do(request queryRequest, body []byte) ([]byte, error) {
+	u, err := url.Parse(request.url)
+	if err != nil {
+		return nil, err
+	}
+	// the Host header omits the default port of the scheme
+	if (u.Scheme == "https" && u.Port() == "443") || (u.Scheme == "http" && u.Port() == "80") {
+		u.Host = u.Hostname()
+	}
+	amzDate := time.Now().UTC().Format("20060102T150405Z")
+	signed := map[string]string{
+		"host":       u.Host,
+		"x-amz-date": amzDate,
+	}
+	headers := append([]string{}, request.headers...)
+	headers = append(headers, "X-Amz-Date: "+amzDate)
+	if len(s.credentials.sessionToken) > 0 {
+		headers = append(headers, "X-Amz-Security-Token: "+s.credentials.sessionToken)
+	}
+	for _, header := range headers {
+		headerSplit := strings.SplitN(header, ":", 2)
+		if len(headerSplit) == 2 {
+			signed[strings.ToLower(strings.TrimSpace(headerSplit[0]))] = strings.TrimSpace(headerSplit[1])
+		}
+	}
+	headers = append(headers, "Authorization: "+s.sign(request.method, u, signed, body))
+	return doRequest(u.String(), request.method, headers, bytes.NewReader(body))
+}
+
+// sign returns the Authorization header of a request signing the headers,
+// which are keyed by their lower case names and include x-amz-date.
+func (s awsSigner) sign(method string, u *url.URL, headers map[string]string, body []byte) string {
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.Join(strings.Fields(headers[name]), " ") + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := u.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	params := []string{}
+	for name, values := range u.Query() {
+		for _, value := range values {
+			params = append(params, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(params)
+
+	canonicalRequest := strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		strings.Join(params, "&"),
+		canonicalHeaders,
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+
+	amzDate := headers["x-amz-date"]
+	date := amzDate
+	if len(date) > 8 {
+		date = date[:8]
+	}
+	scope := strings.Join([]string{date, s.region, s.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := []byte("AWS4" + s.credentials.secretAccessKey)
+	for _, part := range []string{date, s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.credentials.accessKeyId, scope, signedHeaders, signature)
+}
+
+// awsEscape URI encodes a query parameter name or value like AWS does:
+// every byte except the unreserved characters, with spaces as %20.
+func awsEscape(text string) string {
+	return strings.ReplaceAll(url.QueryEscape(text), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestAwsSigner(t *testing.T) {
+	// examples of the AWS Signature Version 4 documentation and test suite
+	credentials := awsCredentials{accessKeyId: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	tests := []struct {
+		name          string
+		service       string
+		method        string
+		url           string
+		headers       map[string]string
+		body          string
+		authorization string
+	}{
+		{
+			name:          "get vanilla",
+			service:       "service",
+			method:        "GET",
+			url:           "https://example.amazonaws.com/",
+			headers:       map[string]string{"host": "example.amazonaws.com", "x-amz-date": "20150830T123600Z"},
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "iam list users",
+			service:       "iam",
+			method:        "GET",
+			url:           "https://iam.amazonaws.com/?Version=2010-05-08&Action=ListUsers",
+			headers:       map[string]string{"content-type": "application/x-www-form-urlencoded; charset=utf-8", "host": "iam.amazonaws.com", "x-amz-date": "20150830T123600Z"},
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+		{
+			name:          "post x-www-form-urlencoded",
+			service:       "service",
+			method:        "POST",
+			url:           "https://example.amazonaws.com/",
+			headers:       map[string]string{"content-type": "application/x-www-form-urlencoded", "host": "example.amazonaws.com", "x-amz-date": "20150830T123600Z"},
+			body:          "Param1=value1",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			signer := awsSigner{credentials: credentials, region: "us-east-1", service: tt.service}
+			if authorization := signer.sign(tt.method, u, tt.headers, []byte(tt.body)); authorization != tt.authorization {
+				t.Errorf("sign() authorization: %v", authorization)
+			}
+		})
+	}
+	if escaped := awsEscape("a b+c~d/e"); escaped != "a%20b%2Bc~d%2Fe" {
+		t.Errorf("awsEscape() = %v", escaped)
+	}
+}
+
+func TestAwsCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	ioutil.WriteFile(credentialsFile, []byte("[default]\naws_access_key_id = AKIDDEFAULT\naws_secret_access_key = default-secret\n\n# comment\n[ops]\naws_access_key_id=AKIDOPS\naws_secret_access_key=ops-secret\naws_session_token=ops-token\n"), 0600)
+	ioutil.WriteFile(configFile, []byte("[default]\nregion = us-east-1\n\n[profile ops]\nregion = eu-west-1\n\n[profile sso]\nregion = ap-southeast-2\naws_access_key_id = AKIDSSO\naws_secret_access_key = sso-secret\n"), 0600)
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		region      string
+		credentials awsCredentials
+		has_err     bool
+	}{
+		{
+			name:        "default profile",
+			region:      "us-east-1",
+			credentials: awsCredentials{accessKeyId: "AKIDDEFAULT", secretAccessKey: "default-secret"},
+		},
+		{
+			name:        "named profile",
+			env:         map[string]string{"AWS_PROFILE": "ops"},
+			region:      "eu-west-1",
+			credentials: awsCredentials{accessKeyId: "AKIDOPS", secretAccessKey: "ops-secret", sessionToken: "ops-token"},
+		},
+		{
+			name:        "config file credentials",
+			env:         map[string]string{"AWS_PROFILE": "sso"},
+			region:      "ap-southeast-2",
+			credentials: awsCredentials{accessKeyId: "AKIDSSO", secretAccessKey: "sso-secret"},
+		},
+		{
+			name:        "environment",
+			env:         map[string]string{"AWS_PROFILE": "ops", "AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret", "AWS_DEFAULT_REGION": "us-west-2"},
+			region:      "us-west-2",
+			credentials: awsCredentials{accessKeyId: "AKIDENV", secretAccessKey: "env-secret"},
+		},
+		{
+			name:    "missing profile",
+			env:     map[string]string{"AWS_PROFILE": "missing"},
+			has_err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{}
+			for _, name := range []string{"AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+				t.Setenv(name, tt.env[name])
+			}
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+			t.Setenv("AWS_CONFIG_FILE", configFile)
+			credentials, err := loadAwsCredentials()
+			if credentials != tt.credentials || (err != nil) != tt.has_err {
+				t.Errorf("loadAwsCredentials() credentials: %+v err: %v", credentials, err)
+			}
+			if region := awsRegion(); region != tt.region {
+				t.Errorf("awsRegion() = %q", region)
+			}
+			plugin.Region = "ca-central-1"
+			if region := awsRegion(); region != "ca-central-1" {
+				t.Errorf("awsRegion() --region = %q", region)
+			}
+		})
+	}
+}
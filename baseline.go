@@ -0,0 +1,18 @@
+package main
+
+import (
+	"time"
+)
+
+// baselineRequest returns the request of the baseline query: --baseline-query
+// (or --query) sent to the --query URL, with the time macros shifted back by
+// the --baseline-offset, if any.
+func baselineRequest(offset time.Duration) (queryRequest, error) {
+	now := templateNow()
+	return prepareRequest("baseline", func() {
+		plugin.now = now.Add(-offset)
+		if len(plugin.BaselineQuery) > 0 {
+			plugin.Query = plugin.BaselineQuery
+		}
+	})
+}
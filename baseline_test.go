@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBaselineQuery(t *testing.T) {
+	var lock sync.Mutex
+	requests := []url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		requests = append(requests, r.Form)
+		lock.Unlock()
+		// requests last week, or with the baseline query, return 100
+		value := 60
+		if r.Form.Get("time") == "1599395200" || r.Form.Get("end") == "1599395200" || r.Form.Get("query") == "sum(rate(http_requests_total[1h] offset 7d))" {
+			value = 100
+		}
+		fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1600000000, "%d"]}]}}`, value)
+	}))
+	defer server.Close()
+	serverUrl, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverUrl.Port())
+
+	tests := []struct {
+		baseline_query  string
+		baseline_offset string
+		range_          string
+		status          int
+		params          []string
+	}{
+		{"", "", "", sensu.CheckStateCritical, []string{""}},
+		{"", "7d", "", sensu.CheckStateWarning, []string{"1600000000", "1599395200"}},
+		{"", "1w", "1h", sensu.CheckStateWarning, []string{"1600000000", "1599395200"}},
+		{"query=sum(rate(http_requests_total[1h] offset 7d))", "", "", sensu.CheckStateWarning, []string{"", ""}},
+	}
+	for _, tt := range tests {
+		requests = []url.Values{}
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.now = time.Unix(1600000000, 0)
+		plugin.Type = "prometheus"
+		plugin.Scheme = "http"
+		plugin.Host = serverUrl.Hostname()
+		plugin.Port = port
+		plugin.Query = "query=sum(rate(http_requests_total[1h]))"
+		plugin.BaselineQuery = tt.baseline_query
+		plugin.BaselineOffset = tt.baseline_offset
+		plugin.Range = tt.range_
+		plugin.Step = "5m"
+		plugin.EvalStatus = 1
+		plugin.EvalStatements = []string{`result.data.result[0].value[1] / baseline.data.result[0].value[1] > 0.5`}
+		plugin.WarningEvals = []string{`result.data.result[0].value[1] / baseline.data.result[0].value[1] > 0.8`}
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			continue
+		}
+		status, err := executeCheck(nil)
+		// without a baseline, the eval statements fail to reference it
+		if status != tt.status || (err != nil) != (len(tt.baseline_query)+len(tt.baseline_offset) == 0) {
+			t.Errorf("executeCheck(nil) baseline query: %q offset: %q status: %v err: %v", tt.baseline_query, tt.baseline_offset, status, err)
+			continue
+		}
+		if len(requests) != len(tt.params) {
+			t.Errorf("executeCheck(nil) sent %d requests, expected %d", len(requests), len(tt.params))
+			continue
+		}
+		times := map[string]bool{}
+		for _, request := range requests {
+			if len(tt.range_) > 0 {
+				times[request.Get("end")] = true
+			} else {
+				times[request.Get("time")] = true
+			}
+		}
+		for _, param := range tt.params {
+			if !times[param] {
+				t.Errorf("executeCheck(nil) requests: %v expected time: %q", requests, param)
+			}
+		}
+	}
+
+	// the baseline response is held to the --max-response-size too
+	large := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err == nil && r.Form.Get("query") == "sum(rate(http_requests_total[1h] offset 7d))" {
+			fmt.Fprint(w, prometheusMatrix(50, 5000))
+			return
+		}
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": []}}`)
+	}))
+	defer large.Close()
+	largeUrl, _ := url.Parse(large.URL)
+	largePort, _ := strconv.Atoi(largeUrl.Port())
+	plugin = Config{}
+	plugin.Type = "prometheus"
+	plugin.Scheme = "http"
+	plugin.Host = largeUrl.Hostname()
+	plugin.Port = largePort
+	plugin.Query = "query=sum(rate(http_requests_total[1h]))"
+	plugin.BaselineQuery = "query=sum(rate(http_requests_total[1h] offset 7d))"
+	plugin.MaxResponseSize = 1
+	plugin.EvalStatus = 1
+	plugin.EvalStatements = []string{`true`}
+	if _, err := checkArgs(nil); err != nil {
+		t.Fatalf("checkArgs(nil) unexpected err: %v", err)
+	}
+	if status, err := executeCheck(nil); status != sensu.CheckStateUnknown || err == nil {
+		t.Errorf("executeCheck(nil) expected baseline response size err, status: %v err: %v", status, err)
+	}
+
+	plugin = Config{}
+	plugin.Url = server.URL
+	plugin.EvalStatus = 1
+	plugin.BaselineOffset = "last week"
+	if status, err := checkArgs(nil); status != sensu.CheckStateWarning || err == nil {
+		t.Errorf("checkArgs(nil) expected invalid --baseline-offset, status: %v err: %v", status, err)
+	}
+}
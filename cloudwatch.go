@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// cloudwatchRange is the time window of GetMetricData requests without
+	// a StartTime, when --range is not set
+	cloudwatchRange = time.Hour
+	// cloudwatchMaxPages bounds the NextToken pagination of a query
+	cloudwatchMaxPages = 100
+)
+
+// cloudwatchResponse is a GetMetricData response, or an error response of
+// the AWS JSON protocol.
+type cloudwatchResponse struct {
+	MetricDataResults []struct {
+		Id         string    `json:"Id"`
+		Label      string    `json:"Label"`
+		Timestamps []float64 `json:"Timestamps"`
+		Values     []float64 `json:"Values"`
+		StatusCode string    `json:"StatusCode"`
+	} `json:"MetricDataResults"`
+	Messages  []cloudwatchMessage `json:"Messages"`
+	NextToken string              `json:"NextToken"`
+	Type      string              `json:"__type"`
+	Message   string              `json:"message"`
+}
+
+// cloudwatchMessage is a message about the data of a GetMetricData response.
+type cloudwatchMessage struct {
+	Code  string `json:"code"`
+	Value string `json:"value"`
+}
+
+// cloudwatchSeries is a metric data result of a GetMetricData query, merged
+// across the pages of the response. CloudWatch returns the timestamps and
+// values as separate lists, newest first; here they are [timestamp, value]
+// pairs, oldest first.
+type cloudwatchSeries struct {
+	Id     string      `json:"id"`
+	Label  string      `json:"label"`
+	Status string      `json:"status"`
+	Values [][]float64 `json:"values"`
+}
+
+// cloudwatchQuery sends the query to the CloudWatch GetMetricData API,
+// signed with the AWS credentials, following the NextToken of partial
+// results. It returns a JSON {"results": [...], "messages": [...]} object.
+func cloudwatchQuery(request queryRequest) ([]byte, error) {
+	region := awsRegion()
+	if len(region) == 0 {
+		return nil, fmt.Errorf("--type=cloudwatch requires a --region (or the AWS_REGION environment variable)")
+	}
+	credentials, err := loadAwsCredentials()
+	if err != nil {
+		return nil, err
+	}
+	signer := awsSigner{credentials: credentials, region: region, service: "monitoring"}
+	now := request.now
+	if now.IsZero() {
+		now = templateNow()
+	}
+	input, err := cloudwatchInput(request.query, now)
+	if err != nil {
+		return nil, err
+	}
+
+	series := []*cloudwatchSeries{}
+	index := map[string]*cloudwatchSeries{}
+	messages := []cloudwatchMessage{}
+	for page := 0; page < cloudwatchMaxPages; page++ {
+		body, err := json.Marshal(input)
+		if err != nil {
+			return nil, err
+		}
+		data, err := signer.do(request, body)
+		if err != nil {
+			return nil, err
+		}
+		var response cloudwatchResponse
+		if err := json.Unmarshal(data, &response); err != nil {
+			return data, fmt.Errorf("CloudWatch request failed: %s", strings.TrimSpace(string(data)))
+		}
+		if len(response.Type) > 0 {
+			errorType := response.Type[strings.LastIndex(response.Type, "#")+1:]
+			return data, fmt.Errorf("CloudWatch request failed: %s: %s", errorType, response.Message)
+		}
+		for _, result := range response.MetricDataResults {
+			current, found := index[result.Id]
+			if !found {
+				current = &cloudwatchSeries{Id: result.Id, Label: result.Label, Values: [][]float64{}}
+				index[result.Id] = current
+				series = append(series, current)
+			}
+			current.Status = result.StatusCode
+			for i := 0; i < len(result.Timestamps) && i < len(result.Values); i++ {
+				current.Values = append(current.Values, []float64{result.Timestamps[i], result.Values[i]})
+			}
+		}
+		messages = append(messages, response.Messages...)
+		if len(response.NextToken) == 0 {
+			break
+		}
+		input["NextToken"] = response.NextToken
+	}
+	for _, s := range series {
+		values := s.Values
+		sort.SliceStable(values, func(i, j int) bool {
+			return values[i][0] < values[j][0]
+		})
+	}
+	return json.Marshal(map[string]interface{}{"results": series, "messages": messages})
+}
+
+// cloudwatchInput returns the GetMetricData request of the query, either a
+// request object or an array of its MetricDataQueries. Missing query ids
+// default to m0, m1, ..., metric periods to the --step, and the StartTime
+// and EndTime to the --range ending now.
+func cloudwatchInput(query string, now time.Time) (map[string]interface{}, error) {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(query), &parsed); err != nil {
+		return nil, fmt.Errorf("Invalid CloudWatch --query: %v", err)
+	}
+	input := map[string]interface{}{}
+	switch value := parsed.(type) {
+	case []interface{}:
+		input["MetricDataQueries"] = value
+	case map[string]interface{}:
+		input = value
+	}
+	queries, ok := input["MetricDataQueries"].([]interface{})
+	if !ok || len(queries) == 0 {
+		return nil, fmt.Errorf("Invalid CloudWatch --query: MetricDataQueries are required")
+	}
+
+	period := 60
+	if d, err := parseDuration(plugin.Step); err == nil && d >= time.Second {
+		period = int(d / time.Second)
+	}
+	for i, item := range queries {
+		metricQuery, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid CloudWatch --query: MetricDataQueries[%d] is not an object", i)
+		}
+		if _, found := metricQuery["Id"]; !found {
+			metricQuery["Id"] = fmt.Sprintf("m%d", i)
+		}
+		if stat, ok := metricQuery["MetricStat"].(map[string]interface{}); ok {
+			if _, found := stat["Period"]; !found {
+				stat["Period"] = period
+			}
+		}
+	}
+
+	window := cloudwatchRange
+	if d, err := parseDuration(plugin.Range); err == nil {
+		window = d
+	}
+	if _, found := input["StartTime"]; !found {
+		input["StartTime"] = now.Add(-window).Unix()
+	}
+	if _, found := input["EndTime"]; !found {
+		input["EndTime"] = now.Unix()
+	}
+	return input, nil
+}
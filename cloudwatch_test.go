@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cloudwatchApi records the GetMetricData inputs of a CloudWatch stub, which
+// returns the results of two pages and rejects requests failing verify.
+type cloudwatchApi struct {
+	token  string
+	inputs []map[string]interface{}
+}
+
+func (s *cloudwatchApi) api() *stubApi {
+	return &stubApi{
+		routes: map[string]http.HandlerFunc{
+			"POST /": func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				if err := s.verify(r); err != nil {
+					w.WriteHeader(http.StatusForbidden)
+					fmt.Fprintf(w, `{"__type": "com.amazon.coral.service#InvalidSignatureException", "message": %q}`, err.Error())
+					return
+				}
+				input := map[string]interface{}{}
+				json.Unmarshal(body, &input)
+				s.inputs = append(s.inputs, input)
+				if input["NextToken"] == nil {
+					fmt.Fprint(w, `{"MetricDataResults": [
+						{"Id": "m0", "Label": "CPUUtilization", "Timestamps": [1599999900, 1599999600], "Values": [72.5, 40], "StatusCode": "PartialData"},
+						{"Id": "errors", "Label": "errors", "Timestamps": [1599999900], "Values": [3], "StatusCode": "Complete"}
+					], "NextToken": "page-2", "Messages": []}`)
+					return
+				}
+				fmt.Fprint(w, `{"MetricDataResults": [
+					{"Id": "m0", "Label": "CPUUtilization", "Timestamps": [1599999300], "Values": [35], "StatusCode": "Complete"}
+				], "Messages": [{"Code": "MaxQueryTimeRangeExceed", "Value": "The query time range exceeds the limit"}]}`)
+			},
+		},
+	}
+}
+
+// verify checks the credential scope of the Authorization header, and that
+// the host, date, target and content type headers are signed. The signature
+// itself is checked against the AWS examples by TestAwsSigner.
+func (s *cloudwatchApi) verify(r *http.Request) error {
+	if r.Header.Get("X-Amz-Target") != "GraniteServiceVersion20100801.GetMetricData" || r.Header.Get("Content-Type") != "application/x-amz-json-1.0" {
+		return fmt.Errorf("unexpected %s request", r.Header.Get("X-Amz-Target"))
+	}
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 ") {
+		return fmt.Errorf("invalid authorization %q", authorization)
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ", ") {
+		if keyValue := strings.SplitN(field, "=", 2); len(keyValue) == 2 {
+			fields[keyValue[0]] = keyValue[1]
+		}
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if _, err := time.Parse("20060102T150405Z", amzDate); err != nil {
+		return fmt.Errorf("invalid date %q", amzDate)
+	}
+	if fields["Credential"] != "AKIDEXAMPLE/"+amzDate[:8]+"/us-east-1/monitoring/aws4_request" {
+		return fmt.Errorf("invalid credential %q", fields["Credential"])
+	}
+	if signature, err := hex.DecodeString(fields["Signature"]); err != nil || len(signature) != sha256.Size {
+		return fmt.Errorf("invalid signature %q", fields["Signature"])
+	}
+	signed := map[string]bool{}
+	for _, name := range strings.Split(fields["SignedHeaders"], ";") {
+		signed[name] = true
+	}
+	required := []string{"host", "x-amz-date", "x-amz-target", "content-type"}
+	if len(s.token) > 0 {
+		required = append(required, "x-amz-security-token")
+	}
+	for _, name := range required {
+		if !signed[name] {
+			return fmt.Errorf("%s header is not signed", name)
+		}
+	}
+	if r.Header.Get("X-Amz-Security-Token") != s.token {
+		return fmt.Errorf("invalid security token")
+	}
+	return nil
+}
+
+func TestCloudwatchQuery(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		access_key string
+		token      string
+		evals      []string
+		pages      int
+		has_err    bool
+	}{
+		{
+			name:       "paginated metric data",
+			query:      `[{"MetricStat": {"Metric": {"Namespace": "AWS/EC2", "MetricName": "CPUUtilization"}, "Stat": "Average"}}, {"Id": "errors", "Expression": "SUM(METRICS())"}]`,
+			access_key: "AKIDEXAMPLE",
+			evals: []string{
+				`result.results.length === 2 && result.results[0].label === "CPUUtilization" && result.results[0].status === "Complete"`,
+				`result.results[0].values.map(v => v[0]).join() === "1599999300,1599999600,1599999900" && stats.last(result.results[0].values) === 72.5`,
+				`stats.last(result.results[1].values) === 3 && result.messages[0].code === "MaxQueryTimeRangeExceed"`,
+			},
+			pages: 2,
+		},
+		{
+			name:       "session token",
+			query:      `{"MetricDataQueries": [{"Id": "cpu", "MetricStat": {"Metric": {"Namespace": "AWS/EC2", "MetricName": "CPUUtilization"}, "Period": 60, "Stat": "Maximum"}}], "StartTime": 1599990000}`,
+			access_key: "AKIDEXAMPLE",
+			token:      "session-token",
+			evals:      []string{`result.results.length === 2`},
+			pages:      2,
+		},
+		{
+			name:       "invalid credential",
+			query:      `[{"Expression": "SUM(METRICS())"}]`,
+			access_key: "AKIDINVALID",
+			pages:      0,
+			has_err:    true,
+		},
+		{
+			name:       "invalid query",
+			query:      `{"StartTime": 1599990000}`,
+			access_key: "AKIDEXAMPLE",
+			has_err:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_ACCESS_KEY_ID", tt.access_key)
+			t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+			t.Setenv("AWS_SESSION_TOKEN", tt.token)
+			stub := &cloudwatchApi{token: tt.token}
+			startStub(t, "cloudwatch", stub.api())
+			plugin.now = time.Unix(1600000000, 0)
+			plugin.Region = "us-east-1"
+			plugin.Query = tt.query
+			plugin.Range = "15m"
+			plugin.Step = "5m"
+			plugin.EvalStatus = 2
+			plugin.EvalStatements = tt.evals
+			if _, err := checkArgs(nil); err != nil {
+				t.Errorf("checkArgs(nil) unexpected err: %v", err)
+				return
+			}
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.has_err || (!tt.has_err && status != sensu.CheckStateOK) {
+				t.Errorf("executeCheck(nil) status: %v err: %v", status, err)
+			}
+			if len(stub.inputs) != tt.pages {
+				t.Errorf("executeCheck(nil) %d requests", len(stub.inputs))
+				return
+			}
+			if tt.pages > 0 && stub.inputs[1]["NextToken"] != "page-2" {
+				t.Errorf("executeCheck(nil) second page input: %v", stub.inputs[1])
+			}
+		})
+	}
+}
+
+func TestCloudwatchInput(t *testing.T) {
+	plugin = Config{}
+	plugin.Range = "15m"
+	plugin.Step = "5m"
+	input, err := cloudwatchInput(`[{"MetricStat": {"Stat": "Average"}}, {"Id": "total", "Expression": "SUM(METRICS())"}, {"MetricStat": {"Period": 60}}]`, time.Unix(1600000000, 0))
+	if err != nil {
+		t.Fatalf("cloudwatchInput() unexpected err: %v", err)
+	}
+	data, _ := json.Marshal(input)
+	expected := `{"EndTime":1600000000,"MetricDataQueries":[{"Id":"m0","MetricStat":{"Period":300,"Stat":"Average"}},{"Expression":"SUM(METRICS())","Id":"total"},{"Id":"m2","MetricStat":{"Period":60}}],"StartTime":1599999100}`
+	if string(data) != expected {
+		t.Errorf("cloudwatchInput() = %s", data)
+	}
+
+	plugin = Config{}
+	input, err = cloudwatchInput(`{"MetricDataQueries": [{"Id": "a"}], "EndTime": "2020-09-13T12:00:00Z"}`, time.Unix(1600000000, 0))
+	if err != nil || input["StartTime"] != int64(1599996400) || input["EndTime"] != "2020-09-13T12:00:00Z" {
+		t.Errorf("cloudwatchInput() input: %v err: %v", input, err)
+	}
+	for _, query := range []string{``, `[]`, `"cpu"`, `[1]`} {
+		if _, err := cloudwatchInput(query, time.Unix(1600000000, 0)); err == nil {
+			t.Errorf("cloudwatchInput(%q) expected err", query)
+		}
+	}
+
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.Type = "cloudwatch"
+	plugin.Region = "eu-west-1"
+	if url, err := finalUrl(); url != "https://monitoring.eu-west-1.amazonaws.com:443/" || err != nil {
+		t.Errorf("finalUrl() url: %v err: %v", url, err)
+	}
+}
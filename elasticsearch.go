@@ -0,0 +1,75 @@
+package main
+
+// elasticsearchHelpers is preloaded into the eval sandbox when
+// --type=elasticsearch is set. It exposes an "es" object with shortcuts into
+// the Search API response shape, so eval statements don't need to handle
+// the differences between Elasticsearch versions (e.g. hits.total being a
+// number in 6.x and an object in 7.x).
+const elasticsearchHelpers = `
+var es = {
+  // total returns the number of matching documents (hits.total)
+  total: function() {
+    if (!result.hits) {
+      return 0;
+    }
+    var total = result.hits.total;
+    if (total !== null && typeof total === "object") {
+      return total.value;
+    }
+    return total || 0;
+  },
+  // hits returns the _source of every returned document
+  hits: function() {
+    if (!result.hits || !result.hits.hits) {
+      return [];
+    }
+    return result.hits.hits.map(function(hit) {
+      return hit._source;
+    });
+  },
+  // aggregation returns the named aggregation or throws if it is missing
+  aggregation: function(name) {
+    if (!result.aggregations || !result.aggregations[name]) {
+      throw new Error("aggregation not found in response: " + name);
+    }
+    return result.aggregations[name];
+  },
+  // value returns the value of a single-value metric aggregation (avg, max, sum, etc.)
+  value: function(name) {
+    return es.aggregation(name).value;
+  },
+  // buckets returns the buckets of a bucket aggregation as an array.
+  // Keyed buckets are converted to an array with a "key" property.
+  buckets: function(name) {
+    var buckets = es.aggregation(name).buckets;
+    if (Array.isArray(buckets)) {
+      return buckets;
+    }
+    return Object.keys(buckets || {}).map(function(key) {
+      var bucket = buckets[key];
+      bucket.key = key;
+      return bucket;
+    });
+  },
+  // percentiles returns a percentiles aggregation as an object mapping
+  // the percent (e.g. 95) to its value, for keyed and unkeyed responses.
+  percentiles: function(name) {
+    var values = es.aggregation(name).values;
+    var percentiles = {};
+    if (Array.isArray(values)) {
+      values.forEach(function(v) {
+        percentiles[parseFloat(v.key)] = v.value;
+      });
+    } else {
+      Object.keys(values || {}).forEach(function(key) {
+        percentiles[parseFloat(key)] = values[key];
+      });
+    }
+    return percentiles;
+  },
+  // percentile returns a single percent value of a percentiles aggregation
+  percentile: function(name, percent) {
+    return es.percentiles(name)[parseFloat(percent)];
+  }
+};
+`
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestElasticsearchUrl(t *testing.T) {
+	tests := []struct {
+		name         string
+		index        string
+		expected_url string
+	}{
+		{
+			name:         "no index",
+			index:        "",
+			expected_url: `http://localhost:9200/_search`,
+		},
+		{
+			name:         "index pattern",
+			index:        "logs-*",
+			expected_url: `http://localhost:9200/logs-*/_search`,
+		},
+		{
+			name:         "multiple indices",
+			index:        "logs,metrics/",
+			expected_url: `http://localhost:9200/logs,metrics/_search`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{
+				PluginConfig: sensu.PluginConfig{
+					Name:  "test",
+					Short: "test",
+				},
+			}
+			plugin.Type = "elasticsearch"
+			plugin.Index = tt.index
+			url, err := finalUrl()
+			if err != nil {
+				t.Errorf("finalUrl() Unexpected return err: %v\n", err)
+				return
+			}
+			if url != tt.expected_url {
+				t.Errorf("finalUrl() Unexpected return url: %v expected: %v\n", url, tt.expected_url)
+				return
+			}
+		})
+	}
+}
+
+func TestElasticsearchHelpers(t *testing.T) {
+	es7 := `{
+		"hits": {"total": {"value": 42, "relation": "eq"}, "hits": [{"_source": {"host": "a"}}]},
+		"aggregations": {
+			"hosts": {"buckets": [{"key": "a", "doc_count": 30}, {"key": "b", "doc_count": 12}]},
+			"ranges": {"buckets": {"fast": {"doc_count": 40}, "slow": {"doc_count": 2}}},
+			"latency": {"values": {"50.0": 120, "95.0": 812}},
+			"latency_unkeyed": {"values": [{"key": 95.0, "value": 640}]},
+			"avg_latency": {"value": 150.5}
+		}
+	}`
+	es6 := `{"hits": {"total": 7, "hits": []}}`
+	tests := []struct {
+		name           string
+		json_data      string
+		jscript        string
+		expect_error   bool
+		expected_value bool
+	}{
+		{
+			name:           "7.x hits total",
+			json_data:      es7,
+			jscript:        `es.total() === 42`,
+			expected_value: true,
+		},
+		{
+			name:           "6.x hits total",
+			json_data:      es6,
+			jscript:        `es.total() === 7`,
+			expected_value: true,
+		},
+		{
+			name:           "hits source",
+			json_data:      es7,
+			jscript:        `es.hits()[0].host === "a"`,
+			expected_value: true,
+		},
+		{
+			name:           "buckets",
+			json_data:      es7,
+			jscript:        `es.buckets("hosts").length === 2 && es.buckets("hosts")[1].doc_count === 12`,
+			expected_value: true,
+		},
+		{
+			name:           "keyed buckets",
+			json_data:      es7,
+			jscript:        `es.buckets("ranges")[1].key === "slow"`,
+			expected_value: true,
+		},
+		{
+			name:           "keyed percentiles",
+			json_data:      es7,
+			jscript:        `es.percentile("latency", 95) === 812 && es.percentiles("latency")[50] === 120`,
+			expected_value: true,
+		},
+		{
+			name:           "unkeyed percentiles",
+			json_data:      es7,
+			jscript:        `es.percentile("latency_unkeyed", 95) < 700`,
+			expected_value: true,
+		},
+		{
+			name:           "single value aggregation",
+			json_data:      es7,
+			jscript:        `es.value("avg_latency") > 150`,
+			expected_value: true,
+		},
+		{
+			name:           "missing aggregation",
+			json_data:      es6,
+			jscript:        `es.buckets("hosts").length > 0`,
+			expect_error:   true,
+			expected_value: false,
+		},
+	}
+	plugin.Type = "elasticsearch"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := processResponse(tt.json_data, tt.jscript)
+			if tt.expect_error != (err != nil) {
+				t.Errorf("processResponse() jscript: %v, expect_error: %v, err: %v\n", tt.jscript, tt.expect_error, err)
+				return
+			}
+			if result != tt.expected_value {
+				t.Errorf("processResponse() jscript: %v, result: %v\n", tt.jscript, result)
+				return
+			}
+		})
+	}
+	plugin.Type = ""
+}
+
+func TestElasticsearchArgs(t *testing.T) {
+	var path, search string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		path, search = r.URL.Path, string(body)
+		fmt.Fprint(w, `{"hits": {"total": {"value": 120, "relation": "eq"}, "hits": []}, "aggregations": {"latency": {"values": {"50.0": 180, "95.0": 420, "99.0": 950}}}}`)
+	}))
+	defer server.Close()
+	serverUrl, _ := url.Parse(server.URL)
+
+	// the helper examples of the README, with quoted arguments and commas
+	query := `{"size": 0, "query": {"range": {"@timestamp": {"gte": "now-5m"}}}, "aggs": {"latency": {"percentiles": {"field": "duration_ms"}}}}`
+	output, err := runCheck(t,
+		"--type", "elasticsearch",
+		"--scheme", "http",
+		"--host", serverUrl.Hostname(),
+		"--port", serverUrl.Port(),
+		"--index", "logs-*",
+		"--query", query,
+		"--eval", "es.total() > 0",
+		"--eval", `es.percentile("latency", 95) < 500`,
+	)
+	if err != nil {
+		t.Fatalf("runCheck() err: %v output: %s", err, output)
+	}
+	if path != "/logs-*/_search" || search != query {
+		t.Errorf("runCheck() path: %v search: %v", path, search)
+	}
+}
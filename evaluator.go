@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+)
+
+// evaluator is the Javascript engine eval statements run in. Variables are
+// seeded into the sandbox as globals before statements are run.
+type evaluator interface {
+	// Set seeds a Go value into the sandbox
+	Set(name string, value interface{}) error
+	// SetJSON seeds a JSON document into the sandbox, parsed by the
+	// engine's JSON.parse
+	SetJSON(name string, data string) error
+	// Run evaluates script and returns the value of its last expression
+	Run(script string) (evalValue, error)
+	// SetLimits bounds the duration of each following Run call and their
+	// total duration. Zero disables the limit.
+	SetLimits(perRun time.Duration, total time.Duration)
+}
+
+// evalLimitError is returned when an eval statement or the response exceeds
+// one of the sandbox limits. The script that exceeded the limit, if any, is
+// quoted after the message.
+type evalLimitError struct {
+	message string
+	script  string
+}
+
+func (e *evalLimitError) Error() string {
+	if len(e.script) > 0 {
+		return fmt.Sprintf("%s: %q", e.message, e.script)
+	}
+	return e.message
+}
+
+// errorStatus returns the check status for an error raised while evaluating
+// statements: unknown if a sandbox limit was hit, critical otherwise.
+func errorStatus(err error) int {
+	var limitErr *evalLimitError
+	if errors.As(err, &limitErr) {
+		return sensu.CheckStateUnknown
+	}
+	return sensu.CheckStateCritical
+}
+
+// evalValue is a value returned by an evaluator.
+type evalValue interface {
+	ToBoolean() bool
+	ToFloat() float64
+	Export() interface{}
+	String() string
+}
+
+// newEvaluator returns an empty Javascript sandbox.
+func newEvaluator() evaluator {
+	return &gojaEvaluator{vm: goja.New()}
+}
+
+// gojaEvaluator runs eval statements using the goja ECMAScript 5.1+ engine,
+// which also supports most of ES2015 and later (arrow functions, let/const,
+// template literals, Array.prototype.find, etc.)
+type gojaEvaluator struct {
+	vm       *goja.Runtime
+	perRun   time.Duration
+	deadline time.Time
+}
+
+func (e *gojaEvaluator) Set(name string, value interface{}) error {
+	return e.vm.Set(name, value)
+}
+
+func (e *gojaEvaluator) SetJSON(name string, data string) error {
+	parse, ok := goja.AssertFunction(e.vm.Get("JSON").ToObject(e.vm).Get("parse"))
+	if !ok {
+		return fmt.Errorf("JSON.parse is not a function")
+	}
+	value, err := parse(goja.Undefined(), e.vm.ToValue(data))
+	if err != nil {
+		return err
+	}
+	return e.vm.Set(name, value)
+}
+
+func (e *gojaEvaluator) SetLimits(perRun time.Duration, total time.Duration) {
+	e.perRun = perRun
+	e.deadline = time.Time{}
+	if total > 0 {
+		e.deadline = time.Now().Add(total)
+	}
+}
+
+func (e *gojaEvaluator) Run(script string) (evalValue, error) {
+	limit := e.perRun
+	reason := fmt.Sprintf("eval statement exceeded the %v eval timeout", limit)
+	if !e.deadline.IsZero() && (limit == 0 || time.Until(e.deadline) < limit) {
+		limit = time.Until(e.deadline)
+		reason = "eval statement exceeded the total eval timeout"
+		if limit <= 0 {
+			return nil, &evalLimitError{message: reason, script: script}
+		}
+	}
+	if limit > 0 {
+		timer := time.AfterFunc(limit, func() {
+			e.vm.Interrupt(&evalLimitError{message: reason, script: script})
+		})
+		defer func() {
+			timer.Stop()
+			e.vm.ClearInterrupt()
+		}()
+	}
+	value, err := e.vm.RunString(script)
+	if err != nil {
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			if limitErr, ok := interrupted.Value().(*evalLimitError); ok {
+				return nil, limitErr
+			}
+		}
+		return nil, err
+	}
+	if value == nil {
+		return goja.Undefined(), nil
+	}
+	return value, nil
+}
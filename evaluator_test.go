@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+// ottoEvaluator is the otto (ES5) engine eval statements used to run in,
+// kept to verify existing eval statements behave the same with goja.
+type ottoEvaluator struct {
+	vm *otto.Otto
+}
+
+type ottoValue struct {
+	otto.Value
+}
+
+func (v ottoValue) ToBoolean() bool {
+	b, _ := v.Value.ToBoolean()
+	return b
+}
+
+func (v ottoValue) ToFloat() float64 {
+	f, _ := v.Value.ToFloat()
+	return f
+}
+
+func (v ottoValue) Export() interface{} {
+	e, _ := v.Value.Export()
+	return e
+}
+
+func (e *ottoEvaluator) Set(name string, value interface{}) error {
+	return e.vm.Set(name, value)
+}
+
+func (e *ottoEvaluator) SetJSON(name string, data string) error {
+	if err := e.vm.Set("input", data); err != nil {
+		return err
+	}
+	_, err := e.vm.Run(fmt.Sprintf("%s = JSON.parse(input)", name))
+	return err
+}
+
+func (e *ottoEvaluator) SetLimits(perRun time.Duration, total time.Duration) {
+}
+
+func (e *ottoEvaluator) Run(script string) (evalValue, error) {
+	value, err := e.vm.Run(script)
+	if err != nil {
+		return nil, err
+	}
+	return ottoValue{value}, nil
+}
+
+func evaluateWith(vm evaluator, data string, script string) (bool, error) {
+	if err := vm.SetJSON("result", data); err != nil {
+		return false, err
+	}
+	value, err := vm.Run(script)
+	if err != nil {
+		return false, err
+	}
+	return value.ToBoolean(), nil
+}
+
+func TestEvaluatorCompatibility(t *testing.T) {
+	prometheus := `{"status": "success", "data": {"resultType": "vector", "result": [
+		{"metric": {"__name__": "up", "instance": "web-1"}, "value": [1600000000.123, "0.42"]},
+		{"metric": {"__name__": "up", "instance": "web-2"}, "value": [1600000000.123, "0.87"]}
+	]}}`
+	influxdb := `{"results": [{"statement_id": 0, "series": [{"name": "app_metrics", "columns": ["time", "mean"], "values": [["2020-09-13T12:26:40Z", 0.0005]]}]}]}`
+	frank := `{"name":"Frank", "car":"Subaru CrossTrek", "empty": "", "zero": 0, "nothing": null, "ts": 1600000000}`
+	tests := []struct {
+		json_data string
+		jscript   string
+	}{
+		{frank, `result.name === "Frank"`},
+		{frank, `result.undefined === "Frank"`},
+		{frank, `result.car.indexOf("Subaru") === 0`},
+		{frank, `result.car.split(" ").length == 2`},
+		{frank, `result.empty`},
+		{frank, `result.zero`},
+		{frank, `result.nothing`},
+		{frank, `result.missing`},
+		{frank, `result.missing.path`},
+		{frank, `result.(`},
+		{frank, `new Date(result.ts * 1000).getUTCFullYear() === 2020`},
+		{frank, `/^fr/i.test(result.name)`},
+		{frank, `typeof result.ts === "number" && typeof result.name === "string"`},
+		{frank, `Object.keys(result).length === 6`},
+		{frank, `JSON.stringify(result.name) === '"Frank"'`},
+		{frank, `parseInt("08") === 8 && parseFloat("0.5e1") === 5`},
+		{frank, `var name = result.name.toUpperCase(); name === "FRANK"`},
+		{prometheus, `result.status`},
+		{prometheus, `!!result.data`},
+		{prometheus, `result.data.result.length > 1`},
+		{prometheus, `result.data.result[0].value[1] < 0.5`},
+		{prometheus, `result.data.result[1].value[1] < 0.5`},
+		{prometheus, `result.data.result[0].value[1] == "0.42"`},
+		{prometheus, `result.data.result.filter(function(r) { return r.value[1] > 0.8 }).length === 0`},
+		{prometheus, `result.data.result.every(function(r) { return r.metric.__name__ === "up" })`},
+		{prometheus, `Math.max.apply(null, result.data.result.map(function(r) { return parseFloat(r.value[1]) })) < 0.9`},
+		{prometheus, `var total = 0; result.data.result.forEach(function(r) { total += parseFloat(r.value[1]) }); total.toFixed(2) === "1.29"`},
+		{prometheus, `result.data.result[0].value[0] % 1 > 0`},
+		{influxdb, `!!result.results`},
+		{influxdb, `result.results[0].series[0].values[0][1] < 0.001`},
+		{influxdb, `result.results[0].series[0].columns.indexOf("mean") === 1`},
+		{influxdb, `Date.parse(result.results[0].series[0].values[0][0]) === 1600000000000`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.jscript, func(t *testing.T) {
+			otto_result, otto_err := evaluateWith(&ottoEvaluator{vm: otto.New()}, tt.json_data, tt.jscript)
+			goja_result, goja_err := evaluateWith(newEvaluator(), tt.json_data, tt.jscript)
+			if (otto_err != nil) != (goja_err != nil) {
+				t.Errorf("error mismatch: otto: %v goja: %v", otto_err, goja_err)
+				return
+			}
+			if otto_result != goja_result {
+				t.Errorf("result mismatch: otto: %v goja: %v", otto_result, goja_result)
+				return
+			}
+		})
+	}
+}
+
+func TestEvaluatorES2015(t *testing.T) {
+	data := `{"data": {"result": [{"metric": {"instance": "web-1"}, "value": [1600000000, "0.42"]}, {"metric": {"instance": "web-2"}, "value": [1600000000, "0.87"]}]}}`
+	tests := []string{
+		`result.data.result.map(r => parseFloat(r.value[1])).every(v => v < 1)`,
+		`let values = result.data.result.map(r => r.value[1]); const max = Math.max(...values); max < 1`,
+		`result.data.result.find(r => r.metric.instance === "web-2").value[1] === "0.87"`,
+		"`${result.data.result[0].metric.instance}` === \"web-1\"",
+		`const { metric } = result.data.result[0]; metric.instance === "web-1"`,
+		`Object.entries(result.data.result[0].metric).length === 1`,
+		`[...new Set(result.data.result.map(r => r.value[0]))].length === 1`,
+		`result.data.result.some(r => r.metric.instance.startsWith("web") && r.metric.instance.includes("-2"))`,
+	}
+	for _, jscript := range tests {
+		t.Run(jscript, func(t *testing.T) {
+			result, err := evaluateWith(newEvaluator(), data, jscript)
+			if err != nil || !result {
+				t.Errorf("evaluate() result: %v err: %v", result, err)
+			}
+		})
+	}
+}
+
+func TestEvaluatorLimits(t *testing.T) {
+	t.Run("eval timeout", func(t *testing.T) {
+		vm := newEvaluator()
+		vm.SetLimits(100*time.Millisecond, 0)
+		_, err := vm.Run(`while(true){}`)
+		if err == nil || errorStatus(err) != sensu.CheckStateUnknown || !strings.Contains(err.Error(), "while(true){}") {
+			t.Errorf("Run() expected eval timeout, err: %v", err)
+			return
+		}
+		value, err := vm.Run(`1 + 1 === 2`)
+		if err != nil || !value.ToBoolean() {
+			t.Errorf("Run() after timeout value: %v err: %v", value, err)
+		}
+	})
+	t.Run("total eval timeout", func(t *testing.T) {
+		vm := newEvaluator()
+		vm.SetLimits(time.Second, 300*time.Millisecond)
+		busy := `var start = Date.now(); while (Date.now() - start < 200) {}; true`
+		if _, err := vm.Run(busy); err != nil {
+			t.Errorf("Run() unexpected err: %v", err)
+			return
+		}
+		_, err := vm.Run(busy)
+		if err == nil || errorStatus(err) != sensu.CheckStateUnknown || !strings.Contains(err.Error(), "total") {
+			t.Errorf("Run() expected total eval timeout, err: %v", err)
+		}
+	})
+	t.Run("max response size", func(t *testing.T) {
+		plugin.MaxResponseSize = 1
+		defer func() { plugin.MaxResponseSize = 0 }()
+		_, err := newSandbox(prometheusMatrix(50, 5000), nil)
+		if err == nil || errorStatus(err) != sensu.CheckStateUnknown {
+			t.Errorf("newSandbox() expected response size err: %v", err)
+		}
+	})
+	t.Run("max response size query", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, prometheusMatrix(50, 5000))
+		}))
+		defer server.Close()
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.Url = server.URL
+		plugin.Request = `GET`
+		plugin.EvalStatus = 1
+		plugin.MaxResponseSize = 1
+		plugin.EvalStatements = []string{`result.status === "success"`}
+		status, err := executeCheck(nil)
+		if status != sensu.CheckStateUnknown || err == nil || !strings.Contains(err.Error(), "--max-response-size") {
+			t.Errorf("executeCheck(nil) status: %v err: %v", status, err)
+		}
+	})
+	t.Run("check status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status": "success"}`)
+		}))
+		defer server.Close()
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.Url = server.URL
+		plugin.Request = `GET`
+		plugin.EvalStatus = 1
+		plugin.EvalTimeout = 1
+		plugin.EvalStatements = []string{`result.status === "success"`, `while(true){}`}
+		status, err := executeCheck(nil)
+		if status != sensu.CheckStateUnknown || err == nil {
+			t.Errorf("executeCheck(nil) status: %v err: %v", status, err)
+		}
+	})
+}
+
+// prometheusMatrix returns a Prometheus range query response with series
+// time series of points each.
+func prometheusMatrix(series int, points int) string {
+	result := []interface{}{}
+	for i := 0; i < series; i++ {
+		values := [][]interface{}{}
+		for j := 0; j < points; j++ {
+			values = append(values, []interface{}{1600000000 + j*15, fmt.Sprintf("%v", float64(j%100)/100)})
+		}
+		result = append(result, map[string]interface{}{
+			"metric": map[string]string{"__name__": "node_load1", "instance": fmt.Sprintf("web-%d:9100", i), "job": "node"},
+			"values": values,
+		})
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": "matrix", "result": result},
+	})
+	return string(data)
+}
+
+func BenchmarkEvaluator(b *testing.B) {
+	data := prometheusMatrix(100, 2000)
+	jscript := strings.TrimSpace(`
+		var max = 0;
+		result.data.result.forEach(function(series) {
+			series.values.forEach(function(value) {
+				max = Math.max(max, parseFloat(value[1]));
+			});
+		});
+		max < 1
+	`)
+	b.Logf("response size: %d bytes", len(data))
+	engines := []struct {
+		name string
+		new  func() evaluator
+	}{
+		{"otto", func() evaluator { return &ottoEvaluator{vm: otto.New()} }},
+		{"goja", newEvaluator},
+	}
+	for _, engine := range engines {
+		b.Run(engine.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				result, err := evaluateWith(engine.new(), data, jscript)
+				if err != nil || !result {
+					b.Fatalf("evaluate() result: %v err: %v", result, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sensu/sensu-go/types"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// eventFile is where the Sensu agent writes the entity and check of checks
+// configured with stdin: true.
+var eventFile = os.Stdin
+
+// eventTimeout bounds the time spent waiting for the event. The agent writes
+// it and closes stdin as soon as the check starts, so checks run from a pipe
+// that stays open (e.g. in a shell pipeline) don't block.
+var eventTimeout = time.Second
+
+// readEvent returns the event written to the file by the Sensu agent, or nil
+// if the file is a terminal (or /dev/null), empty, or not closed within the
+// eventTimeout.
+func readEvent(file *os.File) (*types.Event, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeCharDevice != 0 {
+		return nil, nil
+	}
+	type readResult struct {
+		data []byte
+		err  error
+	}
+	done := make(chan readResult, 1)
+	go func() {
+		data, err := ioutil.ReadAll(file)
+		done <- readResult{data, err}
+	}()
+	var data []byte
+	select {
+	case read := <-done:
+		if read.err != nil {
+			return nil, read.err
+		}
+		data = read.data
+	case <-time.After(eventTimeout):
+		if plugin.Verbose {
+			fmt.Printf("No event read from stdin within %v\n", eventTimeout)
+		}
+		return nil, nil
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	event := &types.Event{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// renderTemplate renders a Go template against the event, e.g.
+// {{ .Entity.Name }} or {{ label .Entity "region" }}, with the time macros.
+func renderTemplate(name string, text string, event *types.Event) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	funcs := templateFuncs()
+	for fname, function := range eventFuncs() {
+		funcs[fname] = function
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, event); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// eventFuncs returns the template functions reading a label or annotation of
+// the entity or check of the event, e.g. {{ annotation .Check "threshold" }}.
+// Unlike index, they fail if the key is missing instead of rendering nothing.
+func eventFuncs() template.FuncMap {
+	return template.FuncMap{
+		"label": func(resource interface{}, key string) (string, error) {
+			meta, err := objectMeta(resource)
+			if err != nil {
+				return "", err
+			}
+			value, found := meta.Labels[key]
+			if !found {
+				return "", fmt.Errorf("%s has no label %q", meta.Name, key)
+			}
+			return value, nil
+		},
+		"annotation": func(resource interface{}, key string) (string, error) {
+			meta, err := objectMeta(resource)
+			if err != nil {
+				return "", err
+			}
+			value, found := meta.Annotations[key]
+			if !found {
+				return "", fmt.Errorf("%s has no annotation %q", meta.Name, key)
+			}
+			return value, nil
+		},
+	}
+}
+
+// objectMeta returns the metadata of the entity or check of the event.
+func objectMeta(resource interface{}) (corev2.ObjectMeta, error) {
+	switch r := resource.(type) {
+	case *corev2.Entity:
+		if r != nil {
+			return r.ObjectMeta, nil
+		}
+	case *corev2.Check:
+		if r != nil {
+			return r.ObjectMeta, nil
+		}
+	}
+	return corev2.ObjectMeta{}, fmt.Errorf("expected the .Entity or .Check of the event, got %v", resource)
+}
+
+// applyEventTemplates renders the --url, --params, --query, --header and eval
+// statement templates against the event.
+func applyEventTemplates(event *types.Event) error {
+	values := map[string]*string{
+		"url":    &plugin.Url,
+		"params": &plugin.ApiParams,
+		"query":  &plugin.Query,
+	}
+	for name, value := range values {
+		rendered, err := renderTemplate(name, *value, event)
+		if err != nil {
+			return templateError(name, err, event)
+		}
+		*value = rendered
+	}
+	lists := map[string]*[]string{
+		"header":        &plugin.Headers,
+		"eval":          &plugin.EvalStatements,
+		"warning-eval":  &plugin.WarningEvals,
+		"critical-eval": &plugin.CriticalEvals,
+	}
+	for name, list := range lists {
+		rendered := []string{}
+		for _, value := range *list {
+			text, err := renderTemplate(name, value, event)
+			if err != nil {
+				return templateError(name, err, event)
+			}
+			rendered = append(rendered, text)
+		}
+		*list = rendered
+	}
+	return nil
+}
+
+// templateError explains a template that could not be rendered, hinting that
+// the event is only available to checks configured with stdin: true.
+func templateError(name string, err error, event *types.Event) error {
+	if event == nil {
+		return fmt.Errorf("--%s template: %v (no event was read from stdin, is stdin: true set in the check definition?)", name, err)
+	}
+	return fmt.Errorf("--%s template: %v", name, err)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeEventFile returns a file containing data, as written to the check
+// stdin by the Sensu agent.
+func writeEventFile(t *testing.T, data string) *os.File {
+	path := filepath.Join(t.TempDir(), "stdin")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+func TestEventTemplates(t *testing.T) {
+	var query, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query = r.Form.Get("query")
+		token = r.Header.Get("X-Scope-OrgID")
+		fmt.Fprint(w, `{"data": {"result": [{"metric": {"instance": "web-1"}, "value": [1600000000, "0.42"]}]}}`)
+	}))
+	defer server.Close()
+
+	eventFile = writeEventFile(t, `{
+		"entity": {"metadata": {"name": "web-1", "namespace": "default", "labels": {"region": "eu-west-1", "tenant": "acme"}}, "entity_class": "agent"},
+		"check": {"metadata": {"name": "cpu", "annotations": {"cpu_threshold": "0.8"}}, "interval": 60}
+	}`)
+	defer func() { eventFile = os.Stdin }()
+
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.Url = server.URL + "/{{ .Entity.Labels.region }}"
+	plugin.Request = `POST`
+	plugin.Headers = []string{"Content-Type: application/x-www-form-urlencoded", "X-Scope-OrgID: {{ label .Entity \"tenant\" }}"}
+	plugin.Query = `query=node_load1{instance="{{ .Entity.Name }}"}`
+	plugin.EvalStatus = 1
+	plugin.EvalStatements = []string{
+		`result.data.result[0].value[1] < {{ annotation .Check "cpu_threshold" }}`,
+		`result.data.result[0].metric.instance === event.entity.metadata.name`,
+	}
+	if _, err := checkArgs(nil); err != nil {
+		t.Errorf("checkArgs(nil) unexpected err: %v", err)
+		return
+	}
+	if plugin.Url != server.URL+"/eu-west-1" || plugin.EvalStatements[0] != `result.data.result[0].value[1] < 0.8` {
+		t.Errorf("checkArgs(nil) url: %v eval statements: %v", plugin.Url, plugin.EvalStatements)
+	}
+	status, err := executeCheck(nil)
+	if status != sensu.CheckStateOK || err != nil {
+		t.Errorf("executeCheck(nil) status: %v err: %v", status, err)
+	}
+	if query != `node_load1{instance="web-1"}` || token != "acme" {
+		t.Errorf("executeCheck(nil) query: %v header: %v", query, token)
+	}
+
+	// unknown labels and templates without an event are errors
+	for _, tt := range []struct {
+		stdin string
+		query string
+		hint  bool
+	}{
+		{`{"entity": {"metadata": {"name": "web-1"}}}`, `{{ .Entity.Labels.region }}`, false},
+		{`{"entity": {"metadata": {"name": "web-1"}}}`, `{{ label .Entity "region" }}`, false},
+		{`{"entity": {"metadata": {"name": "web-1"}}, "check": {"metadata": {"name": "load"}}}`, `{{ annotation .Check "load_threshold" }}`, false},
+		{`{"entity": {"metadata": {"name": "web-1"}}}`, `{{ annotation .Check "load_threshold" }}`, false},
+		{`{"entity": {"metadata": {"name": "web-1"}}}`, `{{ label .Entity.Name "region" }}`, false},
+		{"", `{{ .Entity.Name }}`, true},
+	} {
+		eventFile = writeEventFile(t, tt.stdin)
+		plugin = Config{}
+		plugin.Url = server.URL
+		plugin.EvalStatus = 1
+		plugin.Query = tt.query
+		status, err := checkArgs(nil)
+		if status != sensu.CheckStateWarning || err == nil || strings.Contains(err.Error(), "stdin: true") != tt.hint {
+			t.Errorf("checkArgs(nil) query: %v status: %v err: %v", tt.query, status, err)
+		}
+	}
+}
+
+func TestReadEvent(t *testing.T) {
+	tests := []struct {
+		stdin   string
+		entity  string
+		has_err bool
+	}{
+		{"", "", false},
+		{"\n", "", false},
+		{`{"entity": {"metadata": {"name": "web-1"}}}`, "web-1", false},
+		{`{"entity": `, "", true},
+	}
+	for _, tt := range tests {
+		event, err := readEvent(writeEventFile(t, tt.stdin))
+		entity := ""
+		if event != nil && event.Entity != nil {
+			entity = event.Entity.Name
+		}
+		if entity != tt.entity || (err != nil) != tt.has_err {
+			t.Errorf("readEvent() stdin: %q entity: %v err: %v", tt.stdin, entity, err)
+		}
+	}
+	devnull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devnull.Close()
+	if event, err := readEvent(devnull); event != nil || err != nil {
+		t.Errorf("readEvent() %s event: %v err: %v", os.DevNull, event, err)
+	}
+
+	// a pipe that stays open doesn't block the check
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	defer reader.Close()
+	eventTimeout = 50 * time.Millisecond
+	defer func() { eventTimeout = time.Second }()
+	start := time.Now()
+	if event, err := readEvent(reader); event != nil || err != nil || time.Since(start) > time.Second {
+		t.Errorf("readEvent() open pipe event: %v err: %v after %v", event, err, time.Since(start))
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// forecastMethods maps the --forecast methods to functions returning the
+// fitted slope of the series per second, and the number of seconds until
+// the series reaches the threshold (+Inf if it never does).
+var forecastMethods = map[string]func(points []seriesPoint, threshold float64) (float64, float64, float64){
+	"linear":       linearForecast,
+	"holt-winters": holtWintersForecast,
+}
+
+// forecastResult is the forecast of the --series-path series, available to
+// eval statements and output templates as forecast.
+type forecastResult struct {
+	method    string
+	value     float64
+	slope     float64
+	threshold float64
+	eta       float64
+	at        float64
+	points    int
+	breach    bool
+}
+
+// vars returns the result as a sandbox and template object.
+func (r forecastResult) vars() map[string]interface{} {
+	return map[string]interface{}{
+		"method":    r.method,
+		"value":     r.value,
+		"slope":     r.slope,
+		"threshold": r.threshold,
+		"eta":       r.eta,
+		"at":        r.at,
+		"horizon":   plugin.forecastHorizon.Seconds(),
+		"points":    r.points,
+		"breach":    r.breach,
+	}
+}
+
+// message returns the check output of a forecast reaching the threshold
+// within the --forecast-horizon.
+func (r forecastResult) message() string {
+	eta := time.Duration(r.eta * float64(time.Second)).Round(time.Second)
+	return fmt.Sprintf("Forecast (%s): %.4g is expected to reach %v in %v (slope %.4g per second, horizon %v)",
+		r.method, r.value, r.threshold, eta, r.slope, plugin.forecastHorizon)
+}
+
+// forecast fits the --forecast method to the --series-path series, estimates
+// when it reaches the --forecast-threshold, and seeds the result into the
+// sandbox as forecast. Series too short to fit have a NaN slope and eta.
+func forecast(vm evaluator) (forecastResult, error) {
+	result := forecastResult{method: plugin.Forecast, threshold: plugin.ForecastThreshold, value: math.NaN(), slope: math.NaN(), eta: math.NaN(), at: math.NaN()}
+	points, err := seriesPoints(vm)
+	if err != nil {
+		return result, err
+	}
+	result.points = len(points)
+	result.value, result.slope, result.eta = forecastMethods[plugin.Forecast](points, plugin.ForecastThreshold)
+	if len(points) > 0 {
+		result.at = points[len(points)-1].t + result.eta
+	}
+	result.breach = plugin.forecastHorizon > 0 && result.eta <= plugin.forecastHorizon.Seconds()
+	if plugin.Debug {
+		fmt.Printf("Forecast result: %v\n", result.vars())
+	}
+	return result, vm.Set("forecast", result.vars())
+}
+
+// linearForecast fits a least-squares line to the points, and returns its
+// value at the last point, its slope and when it reaches the threshold.
+func linearForecast(points []seriesPoint, threshold float64) (float64, float64, float64) {
+	if len(points) < 2 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	// timestamps are relative to the first point to keep their precision
+	origin := points[0].t
+	var meanT, meanV float64
+	for _, point := range points {
+		meanT += point.t - origin
+		meanV += point.v
+	}
+	meanT /= float64(len(points))
+	meanV /= float64(len(points))
+	var covariance, variance float64
+	for _, point := range points {
+		covariance += (point.t - origin - meanT) * (point.v - meanV)
+		variance += (point.t - origin - meanT) * (point.t - origin - meanT)
+	}
+	if variance == 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	slope := covariance / variance
+	value := meanV + slope*(points[len(points)-1].t-origin-meanT)
+	return value, slope, linearEta(value, slope, threshold)
+}
+
+// linearEta returns the number of seconds until value reaches the threshold
+// at the rate of slope per second.
+func linearEta(value, slope, threshold float64) float64 {
+	if value == threshold {
+		return 0
+	}
+	if slope == 0 || (threshold > value) != (slope > 0) {
+		return math.Inf(1)
+	}
+	return (threshold - value) / slope
+}
+
+// holtWintersForecast fits additive Holt-Winters (triple exponential)
+// smoothing with a season of --forecast-season points to the points, and
+// returns the smoothed value at the last point, the trend per second and
+// when the forecast first reaches the threshold. The smoothing factors are
+// chosen by a grid search minimizing the one step ahead error. The forecast
+// is searched up to the --forecast-horizon, or 10 times the series length.
+func holtWintersForecast(points []seriesPoint, threshold float64) (float64, float64, float64) {
+	season := plugin.ForecastSeason
+	if season < 2 || len(points) < 2*season {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+	values := make([]float64, len(points))
+	for i, point := range points {
+		values[i] = point.v
+	}
+	step := (points[len(points)-1].t - points[0].t) / float64(len(points)-1)
+	if step <= 0 {
+		return math.NaN(), math.NaN(), math.NaN()
+	}
+
+	var best *holtWinters
+	for alpha := 0.1; alpha < 1; alpha += 0.2 {
+		for beta := 0.1; beta < 1; beta += 0.2 {
+			for gamma := 0.1; gamma < 1; gamma += 0.2 {
+				model := fitHoltWinters(values, season, alpha, beta, gamma)
+				if best == nil || model.sse < best.sse {
+					best = model
+				}
+			}
+		}
+	}
+
+	value := best.forecast(0)
+	steps := 10 * len(points)
+	if plugin.forecastHorizon > 0 {
+		steps = int(math.Ceil(plugin.forecastHorizon.Seconds() / step))
+	}
+	eta := math.Inf(1)
+	if value == threshold {
+		eta = 0
+	}
+	for h := 1; h <= steps && math.IsInf(eta, 1); h++ {
+		next := best.forecast(h)
+		if (threshold > value && next >= threshold) || (threshold < value && next <= threshold) {
+			eta = float64(h) * step
+		}
+	}
+	return value, best.trend / step, eta
+}
+
+// holtWinters is an additive Holt-Winters model fitted to a series.
+type holtWinters struct {
+	level  float64
+	trend  float64
+	season []float64
+	// last is the index of the last value of the series
+	last int
+	// sse is the sum of squared one step ahead errors
+	sse float64
+}
+
+// fitHoltWinters fits the model to values, initialized from their first two
+// seasons.
+func fitHoltWinters(values []float64, period int, alpha, beta, gamma float64) *holtWinters {
+	var first, second float64
+	for i := 0; i < period; i++ {
+		first += values[i]
+		second += values[period+i]
+	}
+	first /= float64(period)
+	second /= float64(period)
+	model := &holtWinters{
+		level:  first,
+		trend:  (second - first) / float64(period),
+		season: make([]float64, period),
+		last:   len(values) - 1,
+	}
+	// the mean of the first season is the level at its middle, so the
+	// seasonal components are detrended, and the level is projected to its
+	// last point
+	middle := float64(period-1) / 2
+	for i := 0; i < period; i++ {
+		model.season[i] = values[i] - (first + model.trend*(float64(i)-middle))
+	}
+	model.level += model.trend * middle
+	for t := period; t < len(values); t++ {
+		seasonal := model.season[t%period]
+		predicted := model.level + model.trend + seasonal
+		model.sse += (values[t] - predicted) * (values[t] - predicted)
+		level := alpha*(values[t]-seasonal) + (1-alpha)*(model.level+model.trend)
+		model.trend = beta*(level-model.level) + (1-beta)*model.trend
+		model.season[t%period] = gamma*(values[t]-level) + (1-gamma)*seasonal
+		model.level = level
+	}
+	return model
+}
+
+// forecast returns the forecast h steps after the last value.
+func (m *holtWinters) forecast(h int) float64 {
+	return m.level + float64(h)*m.trend + m.season[(m.last+h)%len(m.season)]
+}
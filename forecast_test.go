@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForecast(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("test")))
+	defer server.Close()
+
+	tests := []struct {
+		fixture          string
+		forecast         string
+		forecast_horizon string
+		threshold        float64
+		evals            []string
+		status           int
+		output           string
+	}{
+		// disk usage grows 1% per hour, and is 73% full
+		{"forecast-disk.json", "linear", "48h", 100, nil, sensu.CheckStateWarning, "Forecast (linear): 73.06 is expected to reach 100 in 26h48m1s (slope 0.0002792 per second, horizon 48h0m0s)"},
+		{"forecast-disk.json", "linear", "24h", 100, nil, sensu.CheckStateOK, "All eval conditions were met."},
+		{"forecast-disk.json", "linear", "", 100, []string{"forecast.eta > 2 * 86400"}, sensu.CheckStateWarning, `An eval condition was not met: "forecast.eta > 2 * 86400" (false)`},
+		{"forecast-disk.json", "linear", "", 100, []string{"Math.abs(forecast.slope * 3600 - 1) < 0.01 && forecast.at === 1600082800 + forecast.eta && forecast.eta > 26 * 3600"}, sensu.CheckStateOK, "All eval conditions were met."},
+		// a decreasing threshold is never reached
+		{"forecast-disk.json", "linear", "", 20, []string{"forecast.eta === Infinity"}, sensu.CheckStateOK, "All eval conditions were met."},
+		// daily peaks reach the threshold days before the trend does
+		{"forecast-seasonal.json", "linear", "48h", 75, nil, sensu.CheckStateOK, "All eval conditions were met."},
+		{"forecast-seasonal.json", "holt-winters", "48h", 75, nil, sensu.CheckStateWarning, "Forecast (holt-winters): 56.41 is expected to reach 75 in 31h0m0s (slope 5.556e-05 per second, horizon 48h0m0s)"},
+		{"forecast-seasonal.json", "holt-winters", "", 75, []string{"forecast.eta > 86400"}, sensu.CheckStateOK, "All eval conditions were met."},
+	}
+	for _, tt := range tests {
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.Url = server.URL + "/" + tt.fixture
+		plugin.EvalStatus = 1
+		plugin.Forecast = tt.forecast
+		plugin.SeriesPath = "data.result[0].values"
+		plugin.ForecastThreshold = tt.threshold
+		plugin.ForecastHorizon = tt.forecast_horizon
+		plugin.ForecastSeason = 24
+		plugin.EvalStatements = tt.evals
+		plugin.OutputTemplate = "{{ .check.output }}"
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			continue
+		}
+		vm, err := newSandbox(string(mustQuery(t, plugin.Url)), nil)
+		if err != nil {
+			t.Errorf("newSandbox() unexpected err: %v", err)
+			continue
+		}
+		status, output, err := evaluate(vm)
+		if err == nil {
+			output, err = renderOutput(vm, status, output)
+		}
+		if status != tt.status || output != tt.output || err != nil {
+			t.Errorf("evaluate() %s %s horizon %q status: %v output: %q err: %v", tt.fixture, tt.forecast, tt.forecast_horizon, status, output, err)
+		}
+	}
+}
+
+func TestLinearForecast(t *testing.T) {
+	tests := []struct {
+		points    []seriesPoint
+		threshold float64
+		value     float64
+		slope     float64
+		eta       float64
+	}{
+		{[]seriesPoint{{0, 10}, {10, 20}, {20, 30}}, 50, 30, 1, 20},
+		{[]seriesPoint{{0, 30}, {10, 20}, {20, 10}}, 0, 10, -1, 10},
+		{[]seriesPoint{{0, 30}, {10, 20}, {20, 10}}, 50, 10, -1, math.Inf(1)},
+		{[]seriesPoint{{0, 10}, {10, 10}}, 50, 10, 0, math.Inf(1)},
+		{[]seriesPoint{{0, 10}, {10, 20}}, 20, 20, 1, 0},
+		{[]seriesPoint{{0, 10}}, 20, math.NaN(), math.NaN(), math.NaN()},
+		{[]seriesPoint{{5, 10}, {5, 20}}, 20, math.NaN(), math.NaN(), math.NaN()},
+	}
+	same := func(a, b float64) bool {
+		return a == b || (math.IsNaN(a) && math.IsNaN(b)) || math.Abs(a-b) < 1e-9
+	}
+	for _, tt := range tests {
+		value, slope, eta := linearForecast(tt.points, tt.threshold)
+		if !same(value, tt.value) || !same(slope, tt.slope) || !same(eta, tt.eta) {
+			t.Errorf("linearForecast(%v, %v) value: %v slope: %v eta: %v", tt.points, tt.threshold, value, slope, eta)
+		}
+	}
+}
+
+func TestForecastArgs(t *testing.T) {
+	tests := []struct {
+		forecast         string
+		series_path      string
+		forecast_horizon string
+		forecast_season  int
+		has_err          bool
+	}{
+		{"linear", "data.result[0].values", "", 0, false},
+		{"linear", "data.result[0].values", "2d", 0, false},
+		{"holt-winters", "data.result[0].values", "48h", 24, false},
+		{"holt-winters", "data.result[0].values", "48h", 0, true},
+		{"arima", "data.result[0].values", "", 0, true},
+		{"linear", "", "", 0, true},
+		{"linear", "data.result[0].values", "two days", 0, true},
+		{"linear", "data.result[0].values", "-1h", 0, true},
+	}
+	for _, tt := range tests {
+		plugin = Config{}
+		plugin.Url = "http://localhost"
+		plugin.EvalStatus = 1
+		plugin.Forecast = tt.forecast
+		plugin.SeriesPath = tt.series_path
+		plugin.ForecastHorizon = tt.forecast_horizon
+		plugin.ForecastSeason = tt.forecast_season
+		if _, err := checkArgs(nil); (err != nil) != tt.has_err {
+			t.Errorf("checkArgs(nil) forecast: %q series path: %q horizon: %q season: %v err: %v", tt.forecast, tt.series_path, tt.forecast_horizon, tt.forecast_season, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// graphiteSeries is a series of a Graphite render API JSON response. Its
+// datapoints are [value, timestamp] pairs as Graphite returns them, and
+// graphiteQuery adds values, the same points swapped to [timestamp, value].
+type graphiteSeries struct {
+	Target     string                 `json:"target"`
+	Tags       map[string]interface{} `json:"tags,omitempty"`
+	Datapoints [][]interface{}        `json:"datapoints"`
+	Values     [][]interface{}        `json:"values"`
+}
+
+// graphiteQuery renders each line of the query as a Graphite target, and
+// returns the response series with their values as [timestamp, value] pairs.
+// Null values are kept, and ignored by the stats helpers.
+func graphiteQuery(request queryRequest) ([]byte, error) {
+	form := url.Values{}
+	for _, target := range strings.Split(request.query, "\n") {
+		if target = strings.TrimSpace(target); len(target) > 0 {
+			form.Add("target", target)
+		}
+	}
+	if len(form["target"]) == 0 {
+		return nil, fmt.Errorf("Graphite --query requires at least one target")
+	}
+	data, err := doRequest(request.url, request.method, request.headers, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	series := []graphiteSeries{}
+	if err := json.Unmarshal(data, &series); err != nil {
+		return data, fmt.Errorf("Graphite render request failed: %s", strings.TrimSpace(string(data)))
+	}
+	for i := range series {
+		series[i].Values = make([][]interface{}, 0, len(series[i].Datapoints))
+		for _, point := range series[i].Datapoints {
+			if len(point) == 2 {
+				series[i].Values = append(series[i].Values, []interface{}{point[1], point[0]})
+			}
+		}
+	}
+	return json.Marshal(series)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// graphiteWeb records the form of the last render request of a Graphite
+// stub, which renders two series of requests per minute.
+type graphiteWeb struct {
+	form url.Values
+}
+
+func (s *graphiteWeb) api() *stubApi {
+	return &stubApi{
+		routes: map[string]http.HandlerFunc{
+			"POST /render": func(w http.ResponseWriter, r *http.Request) {
+				if r.ParseForm() != nil {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				s.form = r.Form
+				if r.Form.Get("format") != "json" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if r.Form.Get("target") == "invalid(" {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, "Invalid target: invalid(")
+					return
+				}
+				fmt.Fprint(w, `[
+					{"target": "web-1.requests", "tags": {"name": "web-1.requests"}, "datapoints": [[10, 1600000000], [null, 1600000060], [14, 1600000120], [18, 1600000180]]},
+					{"target": "web-2.requests", "tags": {"name": "web-2.requests"}, "datapoints": [[11, 1600000000], [12.5, 1600000060], [null, 1600000120], [null, 1600000180]]}
+				]`)
+			},
+		},
+	}
+}
+
+func TestGraphiteQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		range_  string
+		evals   []string
+		targets []string
+		from    string
+		until   string
+		has_err bool
+	}{
+		{
+			query:  "web-*.requests",
+			range_: "15m",
+			evals: []string{
+				`result.length === 2 && result[0].target === "web-1.requests"`,
+				`stats.count(result[0].values) === 3 && stats.last(result[0].values) === 18 && Math.abs(stats.slope(result[0].values) - 3 / 70) < 1e-9`,
+				`result[1].values[2][1] === null && stats.last(result[1].values) === 12.5`,
+				`result[0].datapoints[0][0] === 10`,
+			},
+			targets: []string{"web-*.requests"},
+			from:    "1599999100",
+			until:   "1600000000",
+		},
+		{
+			query:   "sumSeries(web-*.requests)\nsumSeries(web-*.errors)\n",
+			evals:   []string{`result.length === 2`},
+			targets: []string{"sumSeries(web-*.requests)", "sumSeries(web-*.errors)"},
+		},
+		{
+			query:   "invalid(",
+			targets: []string{"invalid("},
+			has_err: true,
+		},
+	}
+	for _, tt := range tests {
+		stub := &graphiteWeb{}
+		startStub(t, "graphite", stub.api())
+		plugin.now = time.Unix(1600000000, 0)
+		plugin.Query = tt.query
+		plugin.Range = tt.range_
+		plugin.Step = "1m"
+		plugin.EvalStatus = 2
+		plugin.EvalStatements = tt.evals
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			continue
+		}
+		status, err := executeCheck(nil)
+		if (err != nil) != tt.has_err || (!tt.has_err && status != sensu.CheckStateOK) {
+			t.Errorf("executeCheck(nil) query: %q status: %v err: %v", tt.query, status, err)
+		}
+		if !reflect.DeepEqual(stub.form["target"], tt.targets) || stub.form.Get("from") != tt.from || stub.form.Get("until") != tt.until {
+			t.Errorf("executeCheck(nil) query: %q render request: %v", tt.query, stub.form)
+		}
+	}
+
+	serverUrl := startStub(t, "graphite", (&graphiteWeb{}).api())
+	if _, err := graphiteQuery(queryRequest{url: serverUrl.String() + "/render?format=json", method: "POST", query: "\n"}); err == nil {
+		t.Errorf("graphiteQuery() expected missing target err")
+	}
+}
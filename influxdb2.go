@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fluxTable is a table of an InfluxDB 2.x Flux query response. Records hold
+// every column of a row, while values only pair the _time column, in unix
+// seconds, with the _value column, for stats.last(table.values) and co.
+type fluxTable struct {
+	Result  string                   `json:"result"`
+	Table   int64                    `json:"table"`
+	Group   map[string]interface{}   `json:"group"`
+	Columns []string                 `json:"columns"`
+	Records []map[string]interface{} `json:"records"`
+	Values  [][]interface{}          `json:"values"`
+}
+
+// fluxError is the JSON body of a failed InfluxDB 2.x API request.
+type fluxError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// influxdb2Query sends the Flux query to the InfluxDB 2.x query API, and
+// converts the annotated CSV response into a JSON {"tables": [...]} object.
+// The --bucket, if any, is declared as the Flux variable bucket.
+func influxdb2Query(request queryRequest) ([]byte, error) {
+	flux := request.query
+	if len(request.bucket) > 0 {
+		flux = fmt.Sprintf("bucket = %s\n%s", strconv.Quote(request.bucket), flux)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"query": flux,
+		"type":  "flux",
+		"dialect": map[string]interface{}{
+			"header":      true,
+			"delimiter":   ",",
+			"annotations": []string{"datatype", "group", "default"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := doRequest(request.url, request.method, request.headers, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		var response fluxError
+		if err := json.Unmarshal(data, &response); err == nil && len(response.Message) > 0 {
+			return data, fmt.Errorf("InfluxDB query failed: %s: %s", response.Code, response.Message)
+		}
+	}
+	tables, err := parseAnnotatedCSV(data)
+	if err != nil {
+		return data, fmt.Errorf("Could not parse InfluxDB annotated CSV response: %v", err)
+	}
+	return json.Marshal(map[string]interface{}{"tables": tables})
+}
+
+// parseAnnotatedCSV returns the tables of a Flux annotated CSV response,
+// converting the values to the types of the #datatype annotation. Tables
+// of the same result and table number are merged.
+func parseAnnotatedCSV(data []byte) ([]*fluxTable, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	tables := []*fluxTable{}
+	index := map[string]*fluxTable{}
+	var datatypes, groups, defaults, columns []string
+	inData := false
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) > 0 && strings.HasPrefix(row[0], "#") {
+			// annotations after data rows start a table with a new schema
+			if inData {
+				datatypes, groups, defaults, columns = nil, nil, nil, nil
+				inData = false
+			}
+			switch row[0] {
+			case "#datatype":
+				datatypes = row
+			case "#group":
+				groups = row
+			case "#default":
+				defaults = row
+			}
+			continue
+		}
+		if columns == nil {
+			columns = row
+			continue
+		}
+		inData = true
+		record := map[string]interface{}{}
+		for i := 1; i < len(columns) && i < len(row); i++ {
+			value := row[i]
+			if len(value) == 0 && i < len(defaults) {
+				value = defaults[i]
+			}
+			record[columns[i]] = fluxValue(value, annotation(datatypes, i))
+		}
+		// errors during the query are returned as a table of error and
+		// reference columns
+		if message, found := record["error"]; found && hasColumn(columns, "reference") {
+			return nil, fmt.Errorf("%v", message)
+		}
+
+		result, _ := record["result"].(string)
+		table, _ := record["table"].(int64)
+		key := fmt.Sprintf("%s/%d", result, table)
+		current, found := index[key]
+		if !found {
+			current = &fluxTable{
+				Result:  result,
+				Table:   table,
+				Group:   map[string]interface{}{},
+				Columns: []string{},
+				Records: []map[string]interface{}{},
+				Values:  [][]interface{}{},
+			}
+			for i := 1; i < len(columns); i++ {
+				if columns[i] == "result" || columns[i] == "table" {
+					continue
+				}
+				current.Columns = append(current.Columns, columns[i])
+				if annotation(groups, i) == "true" {
+					current.Group[columns[i]] = record[columns[i]]
+				}
+			}
+			index[key] = current
+			tables = append(tables, current)
+		}
+		delete(record, "result")
+		delete(record, "table")
+		current.Records = append(current.Records, record)
+		if t, found := record["_time"]; found {
+			if v, found := record["_value"]; found {
+				current.Values = append(current.Values, []interface{}{fluxTimestamp(t), v})
+			}
+		}
+	}
+	return tables, nil
+}
+
+// fluxValue converts an annotated CSV value to the type of its datatype.
+// Empty values are null, and invalid values are kept as strings.
+func fluxValue(value string, datatype string) interface{} {
+	if len(value) == 0 {
+		return nil
+	}
+	switch datatype {
+	case "long":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case "unsignedLong":
+		if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return n
+		}
+	case "double":
+		// NaN and infinite values are kept as strings, like JSON requires
+		if n, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(n) && !math.IsInf(n, 0) {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// fluxTimestamp returns an RFC3339 time in epoch seconds, as a float for
+// sub-second precision. Other values are returned as they are.
+func fluxTimestamp(value interface{}) interface{} {
+	text, ok := value.(string)
+	if !ok {
+		return value
+	}
+	t, err := time.Parse(time.RFC3339Nano, text)
+	if err != nil {
+		return value
+	}
+	return float64(t.UnixNano()) / 1e9
+}
+
+// annotation returns the annotation of column i, if any.
+func annotation(annotations []string, i int) string {
+	if i < len(annotations) {
+		return annotations[i]
+	}
+	return ""
+}
+
+// hasColumn reports whether the CSV header has the named column.
+func hasColumn(columns []string, name string) bool {
+	for _, column := range columns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fluxResponse is an annotated CSV response of two cpu tables grouped by
+// host, followed by a table of a second result with a different schema.
+const fluxResponse = `#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string
+#group,false,false,true,true,false,false,true,true,true
+#default,_result,,,,,,,,
+,result,table,_start,_stop,_time,_value,_field,_measurement,host
+,,0,2020-09-13T12:00:00Z,2020-09-13T12:30:00Z,2020-09-13T12:10:00Z,41.5,usage_user,cpu,web-1
+,,0,2020-09-13T12:00:00Z,2020-09-13T12:30:00Z,2020-09-13T12:20:00Z,,usage_user,cpu,web-1
+,,0,2020-09-13T12:00:00Z,2020-09-13T12:30:00Z,2020-09-13T12:30:00Z,45.5,usage_user,cpu,web-1
+,,1,2020-09-13T12:00:00Z,2020-09-13T12:30:00Z,2020-09-13T12:10:00Z,80,usage_user,cpu,web-2
+,,1,2020-09-13T12:00:00Z,2020-09-13T12:30:00Z,2020-09-13T12:20:00Z,90.25,usage_user,cpu,web-2
+
+#datatype,string,long,string,long,boolean
+#group,false,false,true,false,false
+#default,hosts,,,,
+,result,table,region,count,healthy
+,,0,eu-west,12,true
+`
+
+// influxd records the Flux query requests of an InfluxDB 2.x API stub,
+// answering them with the response and status.
+type influxd struct {
+	query         map[string]interface{}
+	org           string
+	authorization string
+	accept        string
+	response      string
+	status        int
+}
+
+func (s *influxd) api() *stubApi {
+	return &stubApi{
+		routes: map[string]http.HandlerFunc{
+			"POST /api/v2/query": func(w http.ResponseWriter, r *http.Request) {
+				s.org = r.URL.Query().Get("org")
+				s.authorization = r.Header.Get("Authorization")
+				s.accept = r.Header.Get("Accept")
+				body, _ := ioutil.ReadAll(r.Body)
+				if err := json.Unmarshal(body, &s.query); err != nil || r.Header.Get("Content-Type") != "application/json" {
+					w.WriteHeader(http.StatusBadRequest)
+					fmt.Fprint(w, `{"code": "invalid", "message": "failed to decode request body"}`)
+					return
+				}
+				if s.status > 0 {
+					w.WriteHeader(s.status)
+				}
+				fmt.Fprint(w, s.response)
+			},
+		},
+	}
+}
+
+func TestInfluxDB2Query(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		status   int
+		evals    []string
+		has_err  bool
+	}{
+		{
+			name:     "annotated csv",
+			response: fluxResponse,
+			evals: []string{
+				`result.tables.length === 3`,
+				`result.tables[1].group.host === "web-2" && stats.last(result.tables[1].values) === 90.25`,
+				`stats.count(result.tables[0].values) === 2 && result.tables[0].records[1]._value === null`,
+				`result.tables[0].values[0][0] === 1599999000`,
+				`result.tables[2].result === "hosts" && result.tables[2].records[0].count === 12 && result.tables[2].records[0].healthy`,
+			},
+		},
+		{
+			name:     "query error",
+			response: `{"code": "invalid", "message": "compilation failed: error at @1:1-1:4: undefined identifier fro"}`,
+			status:   http.StatusBadRequest,
+			has_err:  true,
+		},
+		{
+			name:     "error table",
+			response: "#datatype,string,string\n#group,true,true\n#default,,\n,error,reference\n,\"panic: runtime error\",897\n",
+			has_err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &influxd{response: tt.response, status: tt.status}
+			startStub(t, "influxdb2", stub.api())
+			plugin.ApiParams = "org=ops"
+			plugin.Bucket = "telegraf"
+			plugin.Headers = []string{"Authorization: Token c2Vuc3U="}
+			plugin.Query = `from(bucket: bucket) |> range(start: -30m) |> filter(fn: (r) => r._measurement == "cpu")`
+			plugin.EvalStatus = 2
+			plugin.EvalStatements = tt.evals
+			if _, err := checkArgs(nil); err != nil {
+				t.Errorf("checkArgs(nil) unexpected err: %v", err)
+				return
+			}
+			status, err := executeCheck(nil)
+			if (err != nil) != tt.has_err || (!tt.has_err && status != sensu.CheckStateOK) {
+				t.Errorf("executeCheck(nil) status: %v err: %v", status, err)
+			}
+			if stub.org != "ops" || stub.authorization != "Token c2Vuc3U=" || stub.accept != "application/csv" {
+				t.Errorf("executeCheck(nil) org: %q authorization: %q accept: %q", stub.org, stub.authorization, stub.accept)
+			}
+			if stub.query["query"] != "bucket = \"telegraf\"\n"+plugin.Query || stub.query["type"] != "flux" {
+				t.Errorf("executeCheck(nil) query: %v", stub.query)
+			}
+		})
+	}
+}
+
+func TestInfluxDB2Args(t *testing.T) {
+	// the cpu tables of the response, as queried by the README example
+	stub := &influxd{response: strings.SplitN(fluxResponse, "\n\n", 2)[0] + "\n"}
+	serverUrl := startStub(t, "influxdb2", stub.api())
+
+	query := `from(bucket: bucket) |> range(start: -15m) |> filter(fn: (r) => r._measurement == "cpu" and r._field == "usage_user") |> aggregateWindow(every: 1m, fn: mean)`
+	output, err := runCheck(t,
+		"--type", "influxdb2",
+		"--scheme", "http",
+		"--host", serverUrl.Hostname(),
+		"--port", serverUrl.Port(),
+		"--params", "org=ops",
+		"--bucket", "telegraf",
+		"--header", "Authorization: Token c2Vuc3U=",
+		"--query", query,
+		"--eval", "result.tables.every(t => stats.percentile(t.values, 95) < 90)",
+	)
+	if err != nil {
+		t.Fatalf("runCheck() err: %v output: %s", err, output)
+	}
+	if stub.org != "ops" || stub.authorization != "Token c2Vuc3U=" || stub.query["query"] != "bucket = \"telegraf\"\n"+query {
+		t.Errorf("runCheck() org: %q authorization: %q query: %v", stub.org, stub.authorization, stub.query)
+	}
+}
+
+func TestParseAnnotatedCSV(t *testing.T) {
+	tables, err := parseAnnotatedCSV([]byte(fluxResponse))
+	if err != nil {
+		t.Fatalf("parseAnnotatedCSV() unexpected err: %v", err)
+	}
+	if len(tables) != 3 {
+		t.Fatalf("parseAnnotatedCSV() %d tables", len(tables))
+	}
+	expected := &fluxTable{
+		Result:  "_result",
+		Table:   1,
+		Group:   map[string]interface{}{"_start": "2020-09-13T12:00:00Z", "_stop": "2020-09-13T12:30:00Z", "_field": "usage_user", "_measurement": "cpu", "host": "web-2"},
+		Columns: []string{"_start", "_stop", "_time", "_value", "_field", "_measurement", "host"},
+		Records: []map[string]interface{}{
+			{"_start": "2020-09-13T12:00:00Z", "_stop": "2020-09-13T12:30:00Z", "_time": "2020-09-13T12:10:00Z", "_value": float64(80), "_field": "usage_user", "_measurement": "cpu", "host": "web-2"},
+			{"_start": "2020-09-13T12:00:00Z", "_stop": "2020-09-13T12:30:00Z", "_time": "2020-09-13T12:20:00Z", "_value": 90.25, "_field": "usage_user", "_measurement": "cpu", "host": "web-2"},
+		},
+		Values: [][]interface{}{{float64(1599999000), float64(80)}, {float64(1599999600), 90.25}},
+	}
+	if !reflect.DeepEqual(tables[1], expected) {
+		t.Errorf("parseAnnotatedCSV() table: %+v", tables[1])
+	}
+	if tables, err := parseAnnotatedCSV([]byte("")); err != nil || len(tables) != 0 {
+		t.Errorf("parseAnnotatedCSV() empty response tables: %v err: %v", tables, err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+// lokiHelpers is preloaded into the eval sandbox when --type=loki is set. It
+// exposes a "loki" object with shortcuts into the Loki query API response,
+// for both log queries (a "streams" result of log lines) and metric queries
+// (a "matrix" or "vector" result of series, ready for the stats helpers).
+const lokiHelpers = `
+var loki = {
+  // resultType returns the type of the query result: streams, matrix,
+  // vector or scalar
+  resultType: function() {
+    return result.data ? result.data.resultType : undefined;
+  },
+  // streams returns the log streams of a log query result
+  streams: function() {
+    if (loki.resultType() !== "streams") {
+      return [];
+    }
+    return result.data.result || [];
+  },
+  // series returns the series of a metric query result
+  series: function() {
+    var type = loki.resultType();
+    if (type !== "matrix" && type !== "vector") {
+      return [];
+    }
+    return result.data.result || [];
+  },
+  // matches reports whether the labels include all the given labels
+  matches: function(labels, selector) {
+    return Object.keys(selector || {}).every(function(name) {
+      return labels[name] === selector[name];
+    });
+  },
+  // lines returns the log lines of every stream as {time: seconds, line,
+  // labels}, optionally only the lines containing a pattern (a string or
+  // RegExp) in the streams having the labels of a selector object
+  lines: function(pattern, selector) {
+    var lines = [];
+    loki.streams().forEach(function(stream) {
+      var labels = stream.stream || {};
+      if (!loki.matches(labels, selector)) {
+        return;
+      }
+      (stream.values || []).forEach(function(entry) {
+        var line = String(entry[1]);
+        if (pattern instanceof RegExp ? !pattern.test(line) : pattern && line.indexOf(pattern) < 0) {
+          return;
+        }
+        lines.push({time: stats.timestamp(entry[0]), line: line, labels: labels});
+      });
+    });
+    return lines;
+  },
+  // count returns the number of log lines, filtered like lines
+  count: function(pattern, selector) {
+    return loki.lines(pattern, selector).length;
+  },
+  // countBy returns an object mapping the values of a label to their
+  // number of log lines, filtered like lines
+  countBy: function(name, pattern, selector) {
+    var counts = {};
+    loki.lines(pattern, selector).forEach(function(line) {
+      var value = line.labels[name];
+      if (value !== undefined) {
+        counts[value] = (counts[value] || 0) + 1;
+      }
+    });
+    return counts;
+  },
+  // labels returns the label sets of the streams or series, or the sorted
+  // distinct values of the named label
+  labels: function(name) {
+    var sets = loki.streams().map(function(stream) {
+      return stream.stream || {};
+    }).concat(loki.series().map(function(series) {
+      return series.metric || {};
+    }));
+    if (name === undefined) {
+      return sets;
+    }
+    var values = [];
+    sets.forEach(function(labels) {
+      var value = labels[name];
+      if (value !== undefined && values.indexOf(value) < 0) {
+        values.push(value);
+      }
+    });
+    return values.sort();
+  }
+};
+`
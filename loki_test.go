@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLokiHelpers(t *testing.T) {
+	streams := `{"status": "success", "data": {"resultType": "streams", "result": [
+		{"stream": {"app": "checkout", "level": "error"}, "values": [
+			["1600000060000000000", "payment failed: timeout"],
+			["1600000000000000000", "payment failed: card declined"]
+		]},
+		{"stream": {"app": "cart", "level": "error"}, "values": [
+			["1600000030000000000", "redis timeout"]
+		]},
+		{"stream": {"app": "cart", "level": "info"}, "values": [
+			["1600000010000000000", "added item 42"],
+			["1600000020000000000", "added item 7"]
+		]}
+	]}}`
+	matrix := `{"status": "success", "data": {"resultType": "matrix", "result": [
+		{"metric": {"app": "checkout"}, "values": [[1600000000, "2"], [1600000060, "5"], [1600000120, "11"]]},
+		{"metric": {"app": "cart"}, "values": [[1600000000, "0"], [1600000060, "1"]]}
+	]}}`
+	vector := `{"status": "success", "data": {"resultType": "vector", "result": [
+		{"metric": {"app": "checkout"}, "value": [1600000120, "11"]}
+	]}}`
+	tests := []struct {
+		name           string
+		json_data      string
+		jscript        string
+		expect_error   bool
+		expected_value bool
+	}{
+		{
+			name:           "result type",
+			json_data:      streams,
+			jscript:        `loki.resultType() === "streams" && loki.streams().length === 3 && loki.series().length === 0`,
+			expected_value: true,
+		},
+		{
+			name:           "count lines",
+			json_data:      streams,
+			jscript:        `loki.count() === 5`,
+			expected_value: true,
+		},
+		{
+			name:           "count matching lines",
+			json_data:      streams,
+			jscript:        `loki.count("timeout") === 2 && loki.count(/^payment/) === 2 && loki.count(/TIMEOUT/i, {app: "cart"}) === 1`,
+			expected_value: true,
+		},
+		{
+			name:           "count lines by label",
+			json_data:      streams,
+			jscript:        `JSON.stringify(loki.countBy("app", "", {level: "error"})) === '{"checkout":2,"cart":1}'`,
+			expected_value: true,
+		},
+		{
+			name:           "lines",
+			json_data:      streams,
+			jscript:        `loki.lines("redis")[0].time === 1600000030 && loki.lines("redis")[0].labels.app === "cart" && loki.lines("redis")[0].line === "redis timeout"`,
+			expected_value: true,
+		},
+		{
+			name:           "stream labels",
+			json_data:      streams,
+			jscript:        `loki.labels("app").join() === "cart,checkout" && loki.labels().length === 3 && loki.labels("host").length === 0`,
+			expected_value: true,
+		},
+		{
+			name:           "matrix series",
+			json_data:      matrix,
+			jscript:        `loki.count() === 0 && loki.series().length === 2 && stats.last(loki.series()[0].values) === 11 && loki.labels("app").join() === "cart,checkout"`,
+			expected_value: true,
+		},
+		{
+			name:           "vector series",
+			json_data:      vector,
+			jscript:        `loki.resultType() === "vector" && Number(loki.series()[0].value[1]) === 11 && loki.labels()[0].app === "checkout"`,
+			expected_value: true,
+		},
+		{
+			name:         "invalid pattern",
+			json_data:    streams,
+			jscript:      `loki.count(new RegExp("("))`,
+			expect_error: true,
+		},
+	}
+	plugin.Type = "loki"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := processResponse(tt.json_data, tt.jscript)
+			if tt.expect_error != (err != nil) {
+				t.Errorf("processResponse() jscript: %v, expect_error: %v, err: %v\n", tt.jscript, tt.expect_error, err)
+				return
+			}
+			if result != tt.expected_value {
+				t.Errorf("processResponse() jscript: %v, result: %v\n", tt.jscript, result)
+				return
+			}
+		})
+	}
+	plugin.Type = ""
+}
@@ -9,12 +9,14 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
+	"text/template"
 	"time"
 
-	"github.com/robertkrimen/otto"
 	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-go/types"
+	"github.com/spf13/pflag"
 
 	corev2 "github.com/sensu/sensu-go/api/core/v2"
 )
@@ -29,6 +31,8 @@ type Config struct {
 	Url                string
 	EvalStatements     []string
 	EvalStatus         int
+	WarningEvals       []string
+	CriticalEvals      []string
 	Query              string
 	Type               string
 	Verbose            bool
@@ -39,10 +43,64 @@ type Config struct {
 	Port               int
 	ApiPath            string
 	ApiParams          string
+	Index              string
+	Bucket             string
+	Region             string
+	AccessId           string
+	AccessKey          string
 	TrustedCAFile      string
 	InsecureSkipVerify bool
 	MTLSKeyFile        string
 	MTLSCertFile       string
+	PerSeries          string
+	EntityTemplate     string
+	CheckName          string
+	Handlers           []string
+	EventsApi          string
+	Metrics            []string
+	MetricTags         []string
+	MetricFormat       string
+	EvalTimeout        int
+	EvalTotalTimeout   int
+	MaxResponseSize    int
+	EvalFiles          []string
+	Libraries          []string
+	EvalMode           string
+	OutputTemplate     string
+	Range              string
+	Step               string
+	BaselineQuery      string
+	BaselineOffset     string
+	NamedQueries       string
+	StateDir           string
+	StateResultSize    int
+	Anomaly            string
+	SeriesPath         string
+	Sensitivity        float64
+	AnomalyWindow      int
+	Forecast           string
+	ForecastThreshold  float64
+	ForecastHorizon    string
+	ForecastSeason     int
+	Outliers           string
+	OutlierSeries      string
+	OutlierValue       string
+	OutlierLabel       string
+	OutlierGroup       string
+	// eval statements and libraries loaded from files by checkArgs
+	evalFiles []evalStatement
+	libraries []jsFile
+	// event read from stdin by checkArgs
+	event *types.Event
+	// reference time of the template time macros
+	now time.Time
+	// baseline and named query requests rendered by checkArgs
+	baseline     *queryRequest
+	namedQueries []queryRequest
+	// state file path of the check, with --state-dir
+	stateFile string
+	// parsed --forecast-horizon
+	forecastHorizon time.Duration
 }
 
 type ServiceType struct {
@@ -53,6 +111,18 @@ type ServiceType struct {
 	ApiParams string
 	Request   string
 	Headers   []string
+	// IndexPath prefixes ApiPath with --index when set
+	IndexPath bool
+	// RangeApiPath replaces ApiPath and the RangeParams template is appended
+	// to ApiParams when --range is set
+	RangeApiPath string
+	RangeParams  string
+	// InstantParams is appended to ApiParams to evaluate instant queries at
+	// the time of the time macros, when --baseline-offset is set
+	InstantParams string
+	// RegionHost is the Host of the AWS region, formatted with the region
+	// (e.g. "monitoring.%s.amazonaws.com")
+	RegionHost string
 }
 
 var (
@@ -69,6 +139,9 @@ var (
 			Headers: []string{
 				"Content-Type: application/x-www-form-urlencoded",
 			},
+			RangeApiPath:  "api/v1/query_range",
+			RangeParams:   "start={{ start }}&end={{ now }}&step={{ step }}",
+			InstantParams: "time={{ now }}",
 		},
 		"influxdb": ServiceType{
 			Scheme:    "http",
@@ -80,8 +153,102 @@ var (
 			Headers: []string{
 				"Content-Type: application/x-www-form-urlencoded",
 			},
+			RangeParams: "epoch=s",
+		},
+		"influxdb2": ServiceType{
+			Scheme:    "http",
+			Host:      "localhost",
+			Port:      8086,
+			ApiPath:   "api/v2/query",
+			ApiParams: "org=sensu",
+			Request:   "POST",
+			Headers: []string{
+				"Content-Type: application/json",
+				"Accept: application/csv",
+			},
+		},
+		"graphite": ServiceType{
+			Scheme:    "http",
+			Host:      "localhost",
+			Port:      80,
+			ApiPath:   "render",
+			ApiParams: "format=json",
+			Request:   "POST",
+			Headers: []string{
+				"Content-Type: application/x-www-form-urlencoded",
+			},
+			RangeParams:   "from={{ unix start }}&until={{ unix now }}",
+			InstantParams: "from={{ unix (ago \"24h\") }}&until={{ unix now }}",
+		},
+		"loki": ServiceType{
+			Scheme:  "http",
+			Host:    "localhost",
+			Port:    3100,
+			ApiPath: "loki/api/v1/query",
+			Request: "POST",
+			Headers: []string{
+				"Content-Type: application/x-www-form-urlencoded",
+			},
+			RangeApiPath:  "loki/api/v1/query_range",
+			RangeParams:   "start={{ unixns start }}&end={{ unixns now }}&step={{ step }}",
+			InstantParams: "time={{ unixns now }}",
+		},
+		"cloudwatch": ServiceType{
+			Scheme:  "https",
+			Port:    443,
+			Request: "POST",
+			Headers: []string{
+				"Content-Type: application/x-amz-json-1.0",
+				"X-Amz-Target: GraniteServiceVersion20100801.GetMetricData",
+			},
+			RegionHost: "monitoring.%s.amazonaws.com",
+		},
+		"sumologic": ServiceType{
+			Scheme:  "https",
+			Host:    "api.sumologic.com",
+			Port:    443,
+			ApiPath: "api/v1/search/jobs",
+			Request: "POST",
+			Headers: []string{
+				"Content-Type: application/json",
+			},
+		},
+		"elasticsearch": ServiceType{
+			Scheme:  "http",
+			Host:    "localhost",
+			Port:    9200,
+			ApiPath: "_search",
+			Request: "POST",
+			Headers: []string{
+				"Content-Type: application/json",
+			},
+			IndexPath: true,
+		},
+		"splunk": ServiceType{
+			Scheme:    "https",
+			Host:      "localhost",
+			Port:      8089,
+			ApiPath:   "services/search/jobs",
+			ApiParams: "output_mode=json",
+			Request:   "POST",
+			Headers: []string{
+				"Content-Type: application/x-www-form-urlencoded",
+			},
 		},
 	}
+	//Map of Javascript helpers preloaded into the eval sandbox per service type
+	serviceHelpers = map[string]string{
+		"elasticsearch": elasticsearchHelpers,
+		"loki":          lokiHelpers,
+	}
+	//Map of service types that need more than a single request to run a query
+	queryRunners = map[string]func(request queryRequest) ([]byte, error){
+		"splunk":     splunkQuery,
+		"influxdb2":  influxdb2Query,
+		"graphite":   graphiteQuery,
+		"cloudwatch": cloudwatchQuery,
+		"sumologic":  sumologicQuery,
+	}
 	//
 	plugin = Config{
 		PluginConfig: sensu.PluginConfig{
@@ -127,24 +294,6 @@ var (
 			Usage:     "Default to \"get\" unless --query is set, it defaults to \"post\"",
 			Value:     &plugin.Request,
 		},
-		{
-			Path:      "",
-			Env:       "",
-			Argument:  "header",
-			Shorthand: "H",
-			Default:   []string{},
-			Usage:     "HTTP request header(s). Note: some headers may be preset if --type is provided.",
-			Value:     &plugin.Headers,
-		},
-		&sensu.PluginConfigOption{
-			Path:      "",
-			Env:       "",
-			Argument:  "eval",
-			Shorthand: "e",
-			Default:   []string{},
-			Usage:     "Array of Javascript expressions that must return a bool. If no eval is provided, the check will return the query response as standard output. Ex: result.test === \"value\"",
-			Value:     &plugin.EvalStatements,
-		},
 		&sensu.PluginConfigOption{
 			Path:      "",
 			Env:       "",
@@ -222,6 +371,35 @@ var (
 			Usage:    "HTTP request params (e.g. \"db=sensu\")",
 			Value:    &plugin.ApiParams,
 		},
+		&sensu.PluginConfigOption{
+			Path:     "index",
+			Argument: "index",
+			Usage:    "Index name or pattern to search (e.g. \"logs-*\"). Only used with --type=elasticsearch.",
+			Value:    &plugin.Index,
+		},
+		{
+			Argument: "bucket",
+			Default:  "",
+			Usage:    "Bucket name, declared as the Flux variable bucket before the --query (e.g. from(bucket: bucket)). Only used with --type=influxdb2.",
+			Value:    &plugin.Bucket,
+		},
+		{
+			Argument: "region",
+			Usage:    "AWS region, defaults to the AWS_REGION or AWS_DEFAULT_REGION environment variable, or the region of the AWS_PROFILE. Only used with --type=cloudwatch.",
+			Value:    &plugin.Region,
+		},
+		{
+			Argument: "access-id",
+			Env:      "SUMO_ACCESS_ID",
+			Usage:    "Sumo Logic access ID, for basic authentication with --access-key. Only used with --type=sumologic.",
+			Value:    &plugin.AccessId,
+		},
+		{
+			Argument: "access-key",
+			Env:      "SUMO_ACCESS_KEY",
+			Usage:    "Sumo Logic access key, for basic authentication with --access-id. Only used with --type=sumologic.",
+			Value:    &plugin.AccessKey,
+		},
 		{
 			Argument: "insecure-skip-verify",
 			Default:  false,
@@ -246,14 +424,266 @@ var (
 			Usage:    "Certificate file for mutual TLS auth in PEM format",
 			Value:    &plugin.MTLSCertFile,
 		},
+		{
+			Argument: "per-series",
+			Default:  "",
+			Usage:    "Javascript expression returning an array of series (e.g. result.data.result). Eval statements are evaluated once per series element (available as 'series') and each result is sent to the Sensu agent events API as a proxy entity event.",
+			Value:    &plugin.PerSeries,
+		},
+		{
+			Argument: "entity-template",
+			Default:  "{{ .metric.instance }}",
+			Usage:    "Go template rendered against each series element to name its proxy entity (used with --per-series)",
+			Value:    &plugin.EntityTemplate,
+		},
+		{
+			Argument: "check-name",
+			Default:  "",
+			Usage:    "Check name for per-series events (required with --per-series) and the --state-dir state file",
+			Value:    &plugin.CheckName,
+		},
+		{
+			Argument: "handler",
+			Default:  []string{},
+			Usage:    "Sensu event handler(s) for per-series events",
+			Value:    &plugin.Handlers,
+		},
+		{
+			Argument: "events-api",
+			Default:  "http://127.0.0.1:3031/events",
+			Usage:    "Sensu agent events API URL that per-series events are sent to",
+			Value:    &plugin.EventsApi,
+		},
+		{
+			Argument: "metric-tag",
+			Default:  []string{},
+			Usage:    "Tag added to every metric, as key=value",
+			Value:    &plugin.MetricTags,
+		},
+		{
+			Argument: "metric-format",
+			Default:  "graphite_plaintext",
+			Usage:    "Metric output format (graphite_plaintext, influxdb_line, opentsdb_line, prometheus_text, or nagios_perfdata)",
+			Value:    &plugin.MetricFormat,
+		},
+		{
+			Argument: "eval-timeout",
+			Default:  5,
+			Usage:    "Maximum duration of a single eval statement in seconds (0 disables the limit)",
+			Value:    &plugin.EvalTimeout,
+		},
+		{
+			Argument: "eval-total-timeout",
+			Default:  10,
+			Usage:    "Maximum duration of all eval statements in seconds (0 disables the limit)",
+			Value:    &plugin.EvalTotalTimeout,
+		},
+		{
+			Argument: "max-response-size",
+			Default:  64,
+			Usage:    "Maximum size of the query response evaluated in the sandbox in megabytes (0 disables the limit)",
+			Value:    &plugin.MaxResponseSize,
+		},
+		{
+			Argument: "eval-file",
+			Default:  []string{},
+			Usage:    "Javascript file(s) evaluated like an --eval statement. The verdict is the value of the last expression, or of the function it returns or assigns to module.exports called with result. Relative paths are resolved against the current directory, then runtime asset directories.",
+			Value:    &plugin.EvalFiles,
+		},
+		{
+			Argument: "eval-mode",
+			Default:  "bool",
+			Usage:    "How --eval and --eval-file results are interpreted: \"bool\" (a condition that must be met) or \"status\" (a check status 0-3, or a {status, message} object)",
+			Value:    &plugin.EvalMode,
+		},
+		{
+			Argument: "output-template",
+			Default:  "",
+			Usage:    "Check output template rendered against result, series and the eval results (check.status and check.output). Templates containing \"{{\" are Go templates (e.g. {{ .check.output }}), others Javascript template literals (e.g. ${check.output}).",
+			Value:    &plugin.OutputTemplate,
+		},
+		{
+			Argument: "range",
+			Default:  "",
+			Usage:    "Time range to query, ending now (e.g. 15m, 1h or 7d). Runs a range query with --type=prometheus or --type=loki (--step resolution), and returns epoch timestamps with --type=influxdb. The start of the range is available to templates as {{ start }}.",
+			Value:    &plugin.Range,
+		},
+		{
+			Argument: "step",
+			Default:  "1m",
+			Usage:    "Range query resolution (e.g. 30s or 5m), available to templates as {{ step }}",
+			Value:    &plugin.Step,
+		},
+		{
+			Argument: "baseline-query",
+			Default:  "",
+			Usage:    "Baseline query expression, sent to the same URL as --query concurrently. Its response is available to eval statements as 'baseline'.",
+			Value:    &plugin.BaselineQuery,
+		},
+		{
+			Argument: "baseline-offset",
+			Default:  "",
+			Usage:    "Runs --query (or --baseline-query) again as a baseline, with the time macros and --range shifted back by this duration (e.g. 7d for week-over-week comparisons)",
+			Value:    &plugin.BaselineOffset,
+		},
+		{
+			Argument: "named-queries",
+			Default:  "",
+			Usage:    "Additional queries run concurrently, as a JSON object of name: {\"type\": ..., \"url\": ..., \"header\": [...], \"query\": ...} using the names of the flags each query overrides. Their responses are available to eval statements as results.<name>.",
+			Value:    &plugin.NamedQueries,
+		},
+		{
+			Argument: "state-dir",
+			Default:  "",
+			Usage:    "Directory of the state files persisted between check executions, named after the check. Eval statements can read the previous execution results ('previous') and read and write a 'state' object.",
+			Value:    &plugin.StateDir,
+		},
+		{
+			Argument: "state-result-size",
+			Default:  1024,
+			Usage:    "Maximum size of the query response saved in the state file as previous.result in kilobytes, larger responses are saved as null (0 disables the limit)",
+			Value:    &plugin.StateResultSize,
+		},
+		{
+			Argument: "anomaly",
+			Default:  "",
+			Usage:    "Anomaly detection method scoring the last value of the --series-path series against the values before it: \"zscore\", \"ewma\" or \"mad\". Anomalies are reported like an --eval condition that is not met, and the score is available to eval statements as 'anomaly'.",
+			Value:    &plugin.Anomaly,
+		},
+		{
+			Argument: "series-path",
+			Default:  "",
+			Usage:    "Javascript path of the time series scored by --anomaly and --forecast, relative to result (e.g. data.result[0].values)",
+			Value:    &plugin.SeriesPath,
+		},
+		{
+			Argument: "sensitivity",
+			Default:  3.0,
+			Usage:    "Number of standard deviations (or scaled median absolute deviations) from the expected value at which --anomaly reports an anomaly, and --outliers an outlier (the multiple of the interquartile range with --outliers iqr)",
+			Value:    &plugin.Sensitivity,
+		},
+		{
+			Argument: "anomaly-window",
+			Default:  0,
+			Usage:    "Number of trailing values the last value is scored against by --anomaly (0 uses the whole series)",
+			Value:    &plugin.AnomalyWindow,
+		},
+		{
+			Argument: "forecast",
+			Default:  "",
+			Usage:    "Forecasting method fitted to the --series-path series to estimate when it reaches the --forecast-threshold: \"linear\" (least squares) or \"holt-winters\" (seasonal). The estimate is available to eval statements as 'forecast' (e.g. forecast.eta in seconds, and forecast.slope per second).",
+			Value:    &plugin.Forecast,
+		},
+		{
+			Argument: "forecast-threshold",
+			Default:  0.0,
+			Usage:    "Value the --forecast estimates the time to (e.g. the size of a disk)",
+			Value:    &plugin.ForecastThreshold,
+		},
+		{
+			Argument: "forecast-horizon",
+			Default:  "",
+			Usage:    "Reports the --forecast reaching its threshold within this duration (e.g. 48h or 2d) like an --eval condition that is not met",
+			Value:    &plugin.ForecastHorizon,
+		},
+		{
+			Argument: "forecast-season",
+			Default:  0,
+			Usage:    "Number of points per season (e.g. 24 for a daily season of hourly points). Required with --forecast holt-winters, which needs at least two seasons of points.",
+			Value:    &plugin.ForecastSeason,
+		},
+		{
+			Argument: "outliers",
+			Default:  "",
+			Usage:    "Peer comparison method scoring the value of each --outlier-series element against the median of its --outlier-group: \"mad\", \"iqr\" or \"dbscan\". Outliers are reported by label like an --eval condition that is not met, and are available to eval statements as 'outliers'.",
+			Value:    &plugin.Outliers,
+		},
+		{
+			Argument: "outlier-series",
+			Default:  "",
+			Usage:    "Javascript expression returning the array of series compared by --outliers (default set by --type, e.g. result.data.result)",
+			Value:    &plugin.OutlierSeries,
+		},
+		{
+			Argument: "outlier-value",
+			Default:  "",
+			Usage:    "Javascript expression returning the value of each --outlier-series element, available as 'peer' (default set by --type, e.g. stats.last(peer.values || [peer.value]))",
+			Value:    &plugin.OutlierValue,
+		},
+		{
+			Argument: "outlier-label",
+			Default:  "",
+			Usage:    "Go template rendered against each --outlier-series element to label it in the check output (default set by --type, e.g. {{ .metric.instance }})",
+			Value:    &plugin.OutlierLabel,
+		},
+		{
+			Argument: "outlier-group",
+			Default:  "",
+			Usage:    "Go template rendered against each --outlier-series element to group it with its peers (e.g. {{ .metric.job }}). All series are peers by default.",
+			Value:    &plugin.OutlierGroup,
+		},
+		{
+			Argument: "library",
+			Default:  []string{},
+			Usage:    "Javascript file(s) preloaded into the sandbox before eval statements run (e.g. shared functions). Relative paths are resolved against the current directory, then runtime asset directories.",
+			Value:    &plugin.Libraries,
+		},
+	}
+
+	// arrayOptions are the options whose values are often Javascript
+	// expressions (or headers) containing commas. The plugin SDK binds []string
+	// options as comma separated values, so these are bound as string arrays
+	// instead, each occurrence of the flag adding one value.
+	arrayOptions = []*sensu.PluginConfigOption{
+		{
+			Argument:  "header",
+			Shorthand: "H",
+			Default:   []string{},
+			Usage:     "HTTP request header(s). Note: some headers may be preset if --type is provided.",
+			Value:     &plugin.Headers,
+		},
+		{
+			Argument:  "eval",
+			Shorthand: "e",
+			Default:   []string{},
+			Usage:     "Array of Javascript expressions that must return a bool. If no eval is provided, the check will return the query response as standard output. Ex: result.test === \"value\"",
+			Value:     &plugin.EvalStatements,
+		},
+		{
+			Argument: "warning-eval",
+			Default:  []string{},
+			Usage:    "Array of Javascript expressions that must return a bool. The check returns a warning status (1) if any of these conditions is not met.",
+			Value:    &plugin.WarningEvals,
+		},
+		{
+			Argument: "critical-eval",
+			Default:  []string{},
+			Usage:    "Array of Javascript expressions that must return a bool. The check returns a critical status (2) if any of these conditions is not met. Evaluated before --eval and --warning-eval.",
+			Value:    &plugin.CriticalEvals,
+		},
+		{
+			Argument: "metric",
+			Default:  []string{},
+			Usage:    "Metric to output, as name=expression where the Javascript expression returns a number (e.g. api_latency=result.data.result[0].value[1])",
+			Value:    &plugin.Metrics,
+		},
 	}
 )
 
 func main() {
+	bindArrayOptions(pflag.CommandLine)
 	check := sensu.NewGoCheck(&plugin.PluginConfig, options, checkArgs, executeCheck, false)
 	check.Execute()
 }
 
+// bindArrayOptions adds the arrayOptions to the flag set, which the root
+// command of the plugin parses along with its own flags.
+func bindArrayOptions(flags *pflag.FlagSet) {
+	for _, opt := range arrayOptions {
+		flags.StringArrayVarP(opt.Value.(*[]string), opt.Argument, opt.Shorthand, opt.Default.([]string), opt.Usage)
+	}
+}
+
 func serviceDefaults(service ServiceType) {
 	if plugin.Debug {
 		fmt.Printf("Setting service defaults for provider: %s\n", plugin.Type)
@@ -267,12 +697,20 @@ func serviceDefaults(service ServiceType) {
 	}
 	if len(plugin.Host) == 0 {
 		plugin.Host = service.Host
+		if len(service.RegionHost) > 0 {
+			if region := awsRegion(); len(region) > 0 {
+				plugin.Host = fmt.Sprintf(service.RegionHost, region)
+			}
+		}
 	}
 	if plugin.Port == 0 {
 		plugin.Port = service.Port
 	}
 	if len(plugin.ApiPath) == 0 {
 		plugin.ApiPath = service.ApiPath
+		if len(plugin.Range) > 0 && len(service.RangeApiPath) > 0 {
+			plugin.ApiPath = service.RangeApiPath
+		}
 	}
 	if len(plugin.ApiParams) == 0 {
 		plugin.ApiParams = service.ApiParams
@@ -281,12 +719,24 @@ func serviceDefaults(service ServiceType) {
 
 func finalUrl() (string, error) {
 	newUrl := plugin.Url
+	apiPath := plugin.ApiPath
 	if len(plugin.Type) > 0 {
 		if service, found := supportedServices[plugin.Type]; found {
 			if plugin.Debug {
 				fmt.Printf("Found supported service type: %v\n", plugin.Type)
 			}
 			serviceDefaults(service)
+			params, err := timeParams(service)
+			if err != nil {
+				return newUrl, err
+			}
+			if len(params) > 0 {
+				plugin.ApiParams = strings.TrimPrefix(fmt.Sprintf("%v&%v", plugin.ApiParams, params), "&")
+			}
+			apiPath = plugin.ApiPath
+			if service.IndexPath && len(plugin.Index) > 0 {
+				apiPath = fmt.Sprintf("%v/%v", strings.Trim(plugin.Index, "/"), apiPath)
+			}
 		} else {
 			if plugin.Verbose {
 				fmt.Printf("Unknown Service Type: %v\n", plugin.Type)
@@ -294,8 +744,8 @@ func finalUrl() (string, error) {
 		}
 		if len(newUrl) == 0 && len(plugin.Scheme) > 0 && len(plugin.Host) > 0 && plugin.Port > 0 {
 			newUrl = fmt.Sprintf("%v://%v:%v/", plugin.Scheme, plugin.Host, plugin.Port)
-			if len(plugin.ApiPath) > 0 {
-				newUrl = fmt.Sprintf("%v%v", newUrl, plugin.ApiPath)
+			if len(apiPath) > 0 {
+				newUrl = fmt.Sprintf("%v%v", newUrl, apiPath)
 			}
 			if len(plugin.ApiParams) > 0 {
 				newUrl = fmt.Sprintf("%v?%v", newUrl, plugin.ApiParams)
@@ -318,8 +768,53 @@ func checkArgs(event *types.Event) (int, error) {
 		plugin.Verbose = true
 		plugin.Debug = true
 	}
+	if event == nil {
+		var err error
+		event, err = readEvent(eventFile)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Failed to read event from stdin: %v", err)
+		}
+	}
+	plugin.event = event
+	if len(plugin.Range) > 0 {
+		for name, value := range map[string]string{"range": plugin.Range, "step": plugin.Step} {
+			if d, err := parseDuration(value); err != nil || d <= 0 {
+				return sensu.CheckStateWarning, fmt.Errorf("Invalid --%s: %q", name, value)
+			}
+		}
+	}
+	if plugin.Type == "cloudwatch" && len(awsRegion()) == 0 {
+		return sensu.CheckStateWarning, fmt.Errorf("--type=cloudwatch requires a --region (or the AWS_REGION environment variable)")
+	}
+	if len(plugin.BaselineQuery) > 0 || len(plugin.BaselineOffset) > 0 {
+		var offset time.Duration
+		if len(plugin.BaselineOffset) > 0 {
+			var err error
+			offset, err = parseDuration(plugin.BaselineOffset)
+			if err != nil || offset <= 0 {
+				return sensu.CheckStateWarning, fmt.Errorf("Invalid --baseline-offset: %q", plugin.BaselineOffset)
+			}
+		}
+		baseline, err := baselineRequest(offset)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Invalid baseline query: %v", err)
+		}
+		plugin.baseline = &baseline
+	}
+	namedQueries, err := namedRequests()
+	if err != nil {
+		return sensu.CheckStateWarning, fmt.Errorf("Invalid --named-queries: %v", err)
+	}
+	plugin.namedQueries = namedQueries
+	if err := applyEventTemplates(event); err != nil {
+		return sensu.CheckStateWarning, err
+	}
 	newUrl, err := finalUrl()
 	plugin.Url = newUrl
+	// --named-queries checks may not have a --query
+	if err != nil && len(plugin.namedQueries) > 0 && len(plugin.Url) == 0 && len(plugin.Type) == 0 {
+		err = nil
+	}
 
 	if len(plugin.Request) == 0 {
 		plugin.Request = `GET`
@@ -327,16 +822,83 @@ func checkArgs(event *types.Event) (int, error) {
 	plugin.Request = strings.ToUpper(plugin.Request)
 
 	if plugin.Debug {
+		if event != nil && event.Entity != nil {
+			fmt.Printf("  Event Entity: %v\n", event.Entity.Name)
+		}
 		fmt.Printf("  Type: %v\n", plugin.Type)
 		fmt.Printf("  Request Method: %v\n", plugin.Request)
 		fmt.Printf("  Url: %v\n", plugin.Url)
+		fmt.Printf("  Index: %v\n", plugin.Index)
+		fmt.Printf("  Bucket: %v\n", plugin.Bucket)
+		if plugin.Type == "cloudwatch" {
+			fmt.Printf("  Region: %v\n", awsRegion())
+		}
+		if plugin.Type == "sumologic" {
+			fmt.Printf("  Access ID: %v\n", plugin.AccessId)
+		}
 		fmt.Printf("  Trusted CA File: %v\n", plugin.TrustedCAFile)
 		fmt.Printf("  Skip TLS Verify: %v\n", plugin.InsecureSkipVerify)
 		fmt.Printf("  MTLS Cert File: %v\n", plugin.MTLSCertFile)
 		fmt.Printf("  MTLS Key File: %v\n", plugin.MTLSKeyFile)
 		fmt.Printf("  Headers: %v\n", plugin.Headers)
 		fmt.Printf("  Query: %v\n", plugin.Query)
+		if len(plugin.Range) > 0 {
+			fmt.Printf("  Range: %v\n", plugin.Range)
+			fmt.Printf("  Step: %v\n", plugin.Step)
+		}
+		if plugin.baseline != nil {
+			fmt.Printf("  Baseline Url: %v\n", plugin.baseline.url)
+			fmt.Printf("  Baseline Query: %v\n", plugin.baseline.query)
+		}
+		for _, query := range plugin.namedQueries {
+			fmt.Printf("  Named Query %v: %v %v %v %v\n", query.name, query.method, query.url, query.headers, query.query)
+		}
+		if len(plugin.StateDir) > 0 {
+			fmt.Printf("  State Dir: %v\n", plugin.StateDir)
+			fmt.Printf("  State Result Size: %v\n", plugin.StateResultSize)
+		}
 		fmt.Printf("  Eval Statements: %v\n", plugin.EvalStatements)
+		fmt.Printf("  Warning Eval Statements: %v\n", plugin.WarningEvals)
+		fmt.Printf("  Critical Eval Statements: %v\n", plugin.CriticalEvals)
+		fmt.Printf("  Eval Files: %v\n", plugin.EvalFiles)
+		fmt.Printf("  Eval Mode: %v\n", plugin.EvalMode)
+		fmt.Printf("  Output Template: %v\n", plugin.OutputTemplate)
+		fmt.Printf("  Libraries: %v\n", plugin.Libraries)
+		if len(plugin.Anomaly) > 0 {
+			fmt.Printf("  Anomaly: %v\n", plugin.Anomaly)
+			fmt.Printf("  Series Path: %v\n", plugin.SeriesPath)
+			fmt.Printf("  Sensitivity: %v\n", plugin.Sensitivity)
+			fmt.Printf("  Anomaly Window: %v\n", plugin.AnomalyWindow)
+		}
+		if len(plugin.Forecast) > 0 {
+			fmt.Printf("  Forecast: %v\n", plugin.Forecast)
+			fmt.Printf("  Series Path: %v\n", plugin.SeriesPath)
+			fmt.Printf("  Forecast Threshold: %v\n", plugin.ForecastThreshold)
+			fmt.Printf("  Forecast Horizon: %v\n", plugin.ForecastHorizon)
+			fmt.Printf("  Forecast Season: %v\n", plugin.ForecastSeason)
+		}
+		if len(plugin.Outliers) > 0 {
+			fmt.Printf("  Outliers: %v\n", plugin.Outliers)
+			fmt.Printf("  Outlier Series: %v\n", plugin.OutlierSeries)
+			fmt.Printf("  Outlier Value: %v\n", plugin.OutlierValue)
+			fmt.Printf("  Outlier Label: %v\n", plugin.OutlierLabel)
+			fmt.Printf("  Outlier Group: %v\n", plugin.OutlierGroup)
+		}
+		if len(plugin.Metrics) > 0 {
+			fmt.Printf("  Metrics: %v\n", plugin.Metrics)
+			fmt.Printf("  Metric Tags: %v\n", plugin.MetricTags)
+			fmt.Printf("  Metric Format: %v\n", plugin.MetricFormat)
+		}
+		fmt.Printf("  Eval Timeout: %v\n", plugin.EvalTimeout)
+		fmt.Printf("  Eval Total Timeout: %v\n", plugin.EvalTotalTimeout)
+		fmt.Printf("  Max Response Size: %v\n", plugin.MaxResponseSize)
+		if len(plugin.PerSeries) > 0 {
+			fmt.Printf("  Per Series: %v\n", plugin.PerSeries)
+			fmt.Printf("  Entity Template: %v\n", plugin.EntityTemplate)
+			fmt.Printf("  Check Name: %v\n", plugin.CheckName)
+			fmt.Printf("  Handlers: %v\n", plugin.Handlers)
+			fmt.Printf("  Events API: %v\n", plugin.EventsApi)
+		}
 		fmt.Printf("\n")
 		fmt.Printf("Available service types:\n")
 		for name, service := range supportedServices {
@@ -382,6 +944,130 @@ func checkArgs(event *types.Event) (int, error) {
 		tlsConfig.Certificates = []tls.Certificate{cert}
 	}
 
+	if len(plugin.Metrics) > 0 {
+		if _, found := metricFormats[plugin.MetricFormat]; !found {
+			return sensu.CheckStateWarning, fmt.Errorf("Unsupported --metric-format: %v", plugin.MetricFormat)
+		}
+		for _, metric := range plugin.Metrics {
+			if _, _, err := parseNameValue(metric); err != nil {
+				return sensu.CheckStateWarning, fmt.Errorf("Invalid --metric: %v", err)
+			}
+		}
+		for _, tag := range plugin.MetricTags {
+			if _, _, err := parseNameValue(tag); err != nil {
+				return sensu.CheckStateWarning, fmt.Errorf("Invalid --metric-tag: %v", err)
+			}
+		}
+	}
+
+	if len(plugin.EvalMode) == 0 {
+		plugin.EvalMode = "bool"
+	}
+	if plugin.EvalMode != "bool" && plugin.EvalMode != "status" {
+		return sensu.CheckStateWarning, fmt.Errorf("Unsupported --eval-mode: %v", plugin.EvalMode)
+	}
+
+	if len(plugin.Anomaly) > 0 {
+		if _, found := anomalyDetectors[plugin.Anomaly]; !found {
+			return sensu.CheckStateWarning, fmt.Errorf("Unsupported --anomaly: %v", plugin.Anomaly)
+		}
+		if len(plugin.SeriesPath) == 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--anomaly requires --series-path")
+		}
+		if plugin.Sensitivity <= 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--sensitivity must be greater than 0")
+		}
+		if plugin.AnomalyWindow < 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--anomaly-window must not be negative")
+		}
+	}
+
+	if len(plugin.Forecast) > 0 {
+		if _, found := forecastMethods[plugin.Forecast]; !found {
+			return sensu.CheckStateWarning, fmt.Errorf("Unsupported --forecast: %v", plugin.Forecast)
+		}
+		if len(plugin.SeriesPath) == 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--forecast requires --series-path")
+		}
+		if plugin.Forecast == "holt-winters" && plugin.ForecastSeason < 2 {
+			return sensu.CheckStateWarning, fmt.Errorf("--forecast holt-winters requires a --forecast-season of at least 2 points")
+		}
+		plugin.forecastHorizon = 0
+		if len(plugin.ForecastHorizon) > 0 {
+			plugin.forecastHorizon, err = parseDuration(plugin.ForecastHorizon)
+			if err != nil || plugin.forecastHorizon <= 0 {
+				return sensu.CheckStateWarning, fmt.Errorf("Invalid --forecast-horizon: %v", plugin.ForecastHorizon)
+			}
+		}
+	}
+
+	if len(plugin.Outliers) > 0 {
+		if _, found := outlierMethods[plugin.Outliers]; !found {
+			return sensu.CheckStateWarning, fmt.Errorf("Unsupported --outliers: %v", plugin.Outliers)
+		}
+		if defaults, found := peerDefaults[plugin.Type]; found {
+			if len(plugin.OutlierSeries) == 0 {
+				plugin.OutlierSeries = defaults.series
+			}
+			if len(plugin.OutlierValue) == 0 {
+				plugin.OutlierValue = defaults.value
+			}
+			if len(plugin.OutlierLabel) == 0 {
+				plugin.OutlierLabel = defaults.label
+			}
+		}
+		if len(plugin.OutlierSeries) == 0 || len(plugin.OutlierValue) == 0 || len(plugin.OutlierLabel) == 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--outliers requires --outlier-series, --outlier-value and --outlier-label with --type=%v", plugin.Type)
+		}
+		if plugin.Sensitivity <= 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--sensitivity must be greater than 0")
+		}
+		if _, err := template.New("label").Parse(plugin.OutlierLabel); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Invalid --outlier-label: %v", err)
+		}
+		if _, err := template.New("group").Parse(plugin.OutlierGroup); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Invalid --outlier-group: %v", err)
+		}
+	}
+
+	files, err := loadScripts(plugin.EvalFiles)
+	if err != nil {
+		return sensu.CheckStateWarning, fmt.Errorf("Invalid --eval-file: %v", err)
+	}
+	plugin.evalFiles, err = evalFileStatements(files)
+	if err != nil {
+		return sensu.CheckStateWarning, fmt.Errorf("Invalid --eval-file: %v", err)
+	}
+	plugin.libraries, err = loadScripts(plugin.Libraries)
+	if err != nil {
+		return sensu.CheckStateWarning, fmt.Errorf("Invalid --library: %v", err)
+	}
+
+	if len(plugin.StateDir) > 0 {
+		plugin.stateFile, err = statePath(event)
+		if err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Invalid --state-dir: %v", err)
+		}
+	}
+
+	if isGoTemplate(plugin.OutputTemplate) {
+		if _, err := template.New("output").Parse(plugin.OutputTemplate); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Invalid --output-template: %v", err)
+		}
+	}
+
+	if len(plugin.PerSeries) > 0 {
+		if evalCount() == 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--per-series requires at least one eval statement")
+		}
+		if len(plugin.CheckName) == 0 {
+			return sensu.CheckStateWarning, fmt.Errorf("--per-series requires --check-name")
+		}
+		if _, err := template.New("entity").Parse(plugin.EntityTemplate); err != nil {
+			return sensu.CheckStateWarning, fmt.Errorf("Invalid --entity-template: %v", err)
+		}
+	}
+
 	return sensu.CheckStateOK, nil
 }
 
@@ -390,41 +1076,65 @@ func executeCheck(event *types.Event) (int, error) {
 		fmt.Printf(`Dryrun enabled. Query operation aborted`)
 		return sensu.CheckStateOK, nil
 	}
-	response, err := doQuery(plugin.Url, plugin.Request, strings.NewReader(plugin.Query))
-	if plugin.Debug {
-		fmt.Printf("http response: %v\n", string(response))
+	if event == nil {
+		event = plugin.event
 	}
+	response, vars, err := runCheckQueries(event)
 	if err != nil {
-		fmt.Printf("Error attempting query http request: %v\n", err)
-		return sensu.CheckStateCritical, err
-	}
-	if len(plugin.EvalStatements) > 0 {
-		// Loop over eval statements
-		// return on first error or first false eval statement
-		for _, eval := range plugin.EvalStatements {
-			result, err := processResponse(string(response), eval)
-			if plugin.Debug {
-				fmt.Printf("Eval result: %v (%s)\n", result, eval)
+		return errorStatus(err), err
+	}
+	if len(plugin.stateFile) > 0 {
+		state := loadState(plugin.stateFile)
+		vars["previous"] = state.Previous
+		vars["state"] = state.State
+	}
+	// The response is parsed once into a sandbox shared by all statements
+	vm, err := newSandbox(string(response), vars)
+	if err != nil {
+		fmt.Printf("Error attempting to prepare eval sandbox: %v\n", err)
+		return errorStatus(err), err
+	}
+	if len(plugin.PerSeries) > 0 {
+		status, err := executeSeriesCheck(event, vm)
+		if err == nil && len(plugin.stateFile) > 0 {
+			if err := saveState(plugin.stateFile, vm, response, status, "", nil); err != nil {
+				fmt.Printf("Error attempting to save state: %v\n", err)
+				return errorStatus(err), err
 			}
+		}
+		return status, err
+	}
+	if evalCount() > 0 || len(plugin.Metrics) > 0 || len(plugin.OutputTemplate) > 0 {
+		status, output := sensu.CheckStateOK, "No eval statements present."
+		if evalCount() > 0 {
+			status, output, err = evaluate(vm)
 			//return if eval statement throws error
 			if err != nil {
 				fmt.Printf("Error attempting to evaluate http response: %v\n", err)
-				return sensu.CheckStateCritical, err
+				return errorStatus(err), err
 			}
-			//return if eval statement result is false
-			if !result {
-				fmt.Printf("An eval condition was not met: \"%s\" (%v)\n", eval, result)
-				if plugin.Verbose {
-					fmt.Printf("\n%s\n", string(response))
-				}
-				return plugin.EvalStatus, nil
+		}
+		output, err = renderOutput(vm, status, output)
+		if err != nil {
+			fmt.Printf("Error attempting to render check output: %v\n", err)
+			return errorStatus(err), err
+		}
+		points, err := extractMetrics(vm)
+		if err != nil {
+			fmt.Printf("Error attempting to extract metrics: %v\n", err)
+			return errorStatus(err), err
+		}
+		if len(plugin.stateFile) > 0 {
+			if err := saveState(plugin.stateFile, vm, response, status, output, points); err != nil {
+				fmt.Printf("Error attempting to save state: %v\n", err)
+				return errorStatus(err), err
 			}
 		}
-		// If all eval statements result to true
-		fmt.Printf("All eval conditions were met.\n")
+		fmt.Printf("%s\n", appendMetrics(output, points))
 		if plugin.Verbose {
 			fmt.Printf("\n%s\n", string(response))
 		}
+		return status, nil
 	} else {
 		if plugin.Verbose {
 			fmt.Printf("No eval statements present. Returning query result: %v\n", string(response))
@@ -433,19 +1143,217 @@ func executeCheck(event *types.Event) (int, error) {
 		}
 		return sensu.CheckStateOK, nil
 	}
-	return sensu.CheckStateOK, nil
 }
 
+// evaluate runs the eval groups in severity order in the sandbox and
+// returns the status and output for the first eval statement that is not met.
+// The --anomaly, --forecast and --outliers detectors run first, and their
+// findings are reported like a failed --eval.
+func evaluate(vm evaluator) (int, string, error) {
+	findings, err := runDetectors(vm)
+	if err != nil {
+		return sensu.CheckStateCritical, "", err
+	}
+	// Loop over eval groups in severity order
+	// return on first error or first false eval statement
+	for _, group := range evalGroups() {
+		if group.detectors && len(findings) > 0 {
+			return group.status, findings[0], nil
+		}
+		if group.graded {
+			status, output, err := evaluateGraded(vm, group)
+			if err != nil || status != sensu.CheckStateOK {
+				return status, output, err
+			}
+			continue
+		}
+		for _, eval := range group.statements {
+			value, err := runStatement(vm, eval)
+			if err != nil {
+				return sensu.CheckStateCritical, "", err
+			}
+			result := value.ToBoolean()
+			if plugin.Debug {
+				fmt.Printf("Eval result: %v (%s)\n", result, eval.name)
+			}
+			if !result {
+				return group.status, fmt.Sprintf("%s eval condition was not met: \"%s\" (%v)", group.label, eval.name, result), nil
+			}
+		}
+	}
+	// If all eval statements result to true
+	return sensu.CheckStateOK, "All eval conditions were met.", nil
+}
+
+// runDetectors runs the --anomaly, --forecast and --outliers detectors,
+// seeding their results into the sandbox, and returns the output of their
+// findings.
+func runDetectors(vm evaluator) ([]string, error) {
+	findings := []string{}
+	if len(plugin.Anomaly) > 0 {
+		anomaly, err := detectAnomaly(vm)
+		if err != nil {
+			return nil, err
+		}
+		if anomaly.anomalous {
+			findings = append(findings, anomaly.message())
+		}
+	}
+	if len(plugin.Forecast) > 0 {
+		estimate, err := forecast(vm)
+		if err != nil {
+			return nil, err
+		}
+		if estimate.breach {
+			findings = append(findings, estimate.message())
+		}
+	}
+	if len(plugin.Outliers) > 0 {
+		outliers, count, err := detectOutliers(vm)
+		if err != nil {
+			return nil, err
+		}
+		if len(outliers) > 0 {
+			findings = append(findings, outliersMessage(outliers, count))
+		}
+	}
+	return findings, nil
+}
+
+// runStatement runs an eval statement in the sandbox, naming --eval-file
+// statements in the error if it fails. Sandbox limit errors name the file
+// instead of quoting the script it is wrapped in.
+func runStatement(vm evaluator, eval evalStatement) (evalValue, error) {
+	value, err := vm.Run(eval.script)
+	if err != nil {
+		var limitErr *evalLimitError
+		if eval.name != eval.script && errors.As(err, &limitErr) {
+			err = &evalLimitError{message: fmt.Sprintf("%s: %s", eval.name, limitErr.message)}
+		} else if eval.name != eval.script {
+			err = fmt.Errorf("%s: %w", eval.name, err)
+		}
+		fmt.Printf("vm.Run error: %v\n", err)
+		return nil, err
+	}
+	return value, nil
+}
+
+// evalGroup is a set of eval statements sharing the check status returned
+// when one of them is not met.
+type evalGroup struct {
+	label      string
+	status     int
+	statements []evalStatement
+	// graded statements return the check status instead of a bool
+	graded bool
+	// the --anomaly, --forecast and --outliers findings are reported with
+	// the status of the group
+	detectors bool
+}
+
+// evalStatement is a Javascript eval statement and the name it is reported
+// by in the check output: the statement itself, or its --eval-file path.
+type evalStatement struct {
+	name   string
+	script string
+}
+
+// inlineStatements returns eval statements named after their own source.
+func inlineStatements(scripts []string) []evalStatement {
+	statements := []evalStatement{}
+	for _, script := range scripts {
+		statements = append(statements, evalStatement{name: script, script: script})
+	}
+	return statements
+}
+
+// evalCount returns the number of eval statements and eval files, counting
+// the --anomaly, --forecast and --outliers detectors as one each.
+func evalCount() int {
+	count := len(plugin.EvalStatements) + len(plugin.WarningEvals) + len(plugin.CriticalEvals) + len(plugin.evalFiles)
+	if len(plugin.Anomaly) > 0 {
+		count++
+	}
+	if len(plugin.Forecast) > 0 {
+		count++
+	}
+	if len(plugin.Outliers) > 0 {
+		count++
+	}
+	return count
+}
+
+// evalGroups returns the --critical-eval, --eval (and --eval-file) and
+// --warning-eval statements ordered by the severity of their status, highest
+// first, so --critical-eval runs first even with an unknown --result-status.
+func evalGroups() []evalGroup {
+	groups := []evalGroup{
+		{label: "A critical", status: sensu.CheckStateCritical, statements: inlineStatements(plugin.CriticalEvals)},
+		{label: "An", status: plugin.EvalStatus, statements: append(inlineStatements(plugin.EvalStatements), plugin.evalFiles...), graded: plugin.EvalMode == "status", detectors: true},
+		{label: "A warning", status: sensu.CheckStateWarning, statements: inlineStatements(plugin.WarningEvals)},
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return severity(groups[i].status) > severity(groups[j].status)
+	})
+	return groups
+}
+
+// runQuery executes the query using the --type and --header.
+func runQuery(urlString string, requestType string, query string) ([]byte, error) {
+	return runRequest(queryRequest{
+		serviceType: plugin.Type,
+		url:         urlString,
+		method:      requestType,
+		headers:     plugin.Headers,
+		query:       query,
+		bucket:      plugin.Bucket,
+	})
+}
+
+// runRequest executes the request using the query runner registered for its
+// service type, or a single http request otherwise. The response of a query
+// runner, converted or gathered from several requests, is checked against the
+// --max-response-size too.
+func runRequest(request queryRequest) ([]byte, error) {
+	runner, found := queryRunners[request.serviceType]
+	if !found {
+		return doRequest(request.url, request.method, request.headers, strings.NewReader(request.query))
+	}
+	data, err := runner(request)
+	if err != nil {
+		return nil, err
+	}
+	if plugin.MaxResponseSize > 0 && len(data) > plugin.MaxResponseSize*1024*1024 {
+		return nil, responseSizeError()
+	}
+	return data, nil
+}
+
+// doQuery sends an http request with the --header headers.
 func doQuery(urlString string, requestType string, data io.Reader) ([]byte, error) {
-	client := http.DefaultClient
-	client.Transport = http.DefaultTransport
-	client.Timeout = time.Duration(plugin.Timeout) * time.Second
+	return doRequest(urlString, requestType, plugin.Headers, data)
+}
+
+func doRequest(urlString string, requestType string, headers []string, data io.Reader) ([]byte, error) {
+	return doSessionRequest(nil, urlString, requestType, headers, data)
+}
+
+// doSessionRequest sends an http request keeping the cookies of the jar, if
+// any, for APIs that track sessions with cookies.
+func doSessionRequest(jar http.CookieJar, urlString string, requestType string, headers []string, data io.Reader) ([]byte, error) {
+	// Queries may run concurrently, each uses its own client and transport
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	client := &http.Client{
+		Transport: transport,
+		Jar:       jar,
+		Timeout:   time.Duration(plugin.Timeout) * time.Second,
+	}
 	checkURL, err := url.Parse(urlString)
 	if err != nil {
 		return nil, err
 	}
 	if checkURL.Scheme == "https" {
-		client.Transport.(*http.Transport).TLSClientConfig = &tlsConfig
+		transport.TLSClientConfig = &tlsConfig
 	}
 
 	req, err := http.NewRequest(requestType, urlString, data)
@@ -454,8 +1362,8 @@ func doQuery(urlString string, requestType string, data io.Reader) ([]byte, erro
 	}
 
 	req.Header.Set("Accept", "application/json")
-	if len(plugin.Headers) > 0 {
-		for _, header := range plugin.Headers {
+	if len(headers) > 0 {
+		for _, header := range headers {
 			headerSplit := strings.SplitN(header, ":", 2)
 			req.Header.Set(strings.TrimSpace(headerSplit[0]), strings.TrimSpace(headerSplit[1]))
 		}
@@ -472,48 +1380,90 @@ func doQuery(urlString string, requestType string, data io.Reader) ([]byte, erro
 		return nil, err
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	// Only read one byte past the --max-response-size, so large responses
+	// are never buffered whole
+	reader := io.Reader(resp.Body)
+	limit := int64(plugin.MaxResponseSize) * 1024 * 1024
+	if limit > 0 {
+		reader = io.LimitReader(resp.Body, limit+1)
+	}
+	body, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return nil, err
 	}
-
-	var jsonBody interface{}
-
-	err = json.Unmarshal(body, &jsonBody)
-	if err != nil {
-		return nil, fmt.Errorf("Could not unmarshal response body into JSON: %v", err)
+	if limit > 0 && int64(len(body)) > limit {
+		return nil, responseSizeError()
 	}
 	return body, nil
 }
 
-func processResponse(data string, jscript string) (bool, error) {
-	vm := otto.New()
+// responseSizeError is the error of a query response exceeding the
+// --max-response-size.
+func responseSizeError() error {
+	return &evalLimitError{
+		message: fmt.Sprintf("query response exceeds the --max-response-size of %d MB", plugin.MaxResponseSize),
+	}
+}
 
-	err := vm.Set("input", data)
+// processResponse evaluates a single jscript statement in a new sandbox
+// seeded with the response.
+func processResponse(data string, jscript string) (bool, error) {
+	vm, err := newSandbox(data, nil)
 	if err != nil {
-		fmt.Printf("vm.Set error: %v", err)
 		return false, err
 	}
-	_, err = vm.Run(`
-          result = JSON.parse(input)
-        `)
+	return_value, err := vm.Run(jscript)
 	if err != nil {
-		fmt.Printf("vm.Run error: %v", err)
+		fmt.Printf("vm.Run error: %v\n", err)
 		return false, err
 	}
-	return_value, err := vm.Run(jscript)
+	return return_value.ToBoolean(), nil
+}
+
+// newSandbox returns an evaluator seeded with the response as result, each
+// of vars (encoded as JSON) by name, the stats helpers, the helpers for the
+// service type, and the --library files.
+func newSandbox(data string, vars map[string]interface{}) (evaluator, error) {
+	if plugin.MaxResponseSize > 0 && len(data) > plugin.MaxResponseSize*1024*1024 {
+		return nil, responseSizeError()
+	}
+	vm := newEvaluator()
+	vm.SetLimits(time.Duration(plugin.EvalTimeout)*time.Second, time.Duration(plugin.EvalTotalTimeout)*time.Second)
+
+	err := vm.SetJSON("result", data)
 	if err != nil {
-		fmt.Printf("vm.Run error: %v", err)
-		return false, err
-	} else {
-		if return_bool, err := return_value.ToBoolean(); err == nil {
-			if err != nil {
-				fmt.Printf("return_value.ToBoolean error: %v\n", err)
-				return return_bool, err
-			} else {
-				return return_bool, err
-			}
+		fmt.Printf("vm.SetJSON error: %v\n", err)
+		return nil, fmt.Errorf("Could not unmarshal response body into JSON: %v", err)
+	}
+	for name, value := range vars {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		err = vm.SetJSON(name, string(encoded))
+		if err != nil {
+			fmt.Printf("vm.SetJSON error: %v\n", err)
+			return nil, err
+		}
+	}
+	_, err = vm.Run(statsHelpers)
+	if err != nil {
+		fmt.Printf("vm.Run error: %v\n", err)
+		return nil, err
+	}
+	if helpers, found := serviceHelpers[plugin.Type]; found {
+		_, err = vm.Run(helpers)
+		if err != nil {
+			fmt.Printf("vm.Run error: %v\n", err)
+			return nil, err
+		}
+	}
+	for _, library := range plugin.libraries {
+		_, err = vm.Run(library.source)
+		if err != nil {
+			fmt.Printf("vm.Run error: %v\n", err)
+			return nil, fmt.Errorf("library %s: %w", library.path, err)
 		}
 	}
-	return false, fmt.Errorf("processResponse: unknown error")
+	return vm, nil
 }
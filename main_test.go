@@ -1,15 +1,96 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 )
 
 func TestMain(t *testing.T) {
 }
 
+// TestCheckProcess runs the check with the command line arguments of
+// runCheck, in the child process it starts. The process exits with the check
+// status.
+func TestCheckProcess(t *testing.T) {
+	args := os.Getenv("TEST_CHECK_ARGS")
+	if len(args) == 0 {
+		return
+	}
+	os.Args = []string{"sensu-data-analysis"}
+	if err := json.Unmarshal([]byte(args), &os.Args); err != nil {
+		t.Fatal(err)
+	}
+	main()
+}
+
+// runCheck runs the check with the command line arguments, parsed like those
+// of the plugin binary, and returns its output.
+func runCheck(t *testing.T, args ...string) ([]byte, error) {
+	data, err := json.Marshal(append([]string{"sensu-data-analysis"}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestCheckProcess$")
+	cmd.Env = append(os.Environ(), "TEST_CHECK_ARGS="+string(data))
+	return cmd.CombinedOutput()
+}
+
+// stubApi serves the handlers of its routes, keyed by method and path like
+// "POST /render", standing in for the API of a service type. Other requests
+// get a 404 response. Requests are handled one at a time, so handlers can
+// record them without locking.
+type stubApi struct {
+	sync.Mutex
+	routes map[string]http.HandlerFunc
+	// before runs first for every request, if set, returning false when it
+	// responded itself
+	before func(w http.ResponseWriter, r *http.Request) bool
+}
+
+func (s *stubApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if s.before != nil && !s.before(w, r) {
+		return
+	}
+	handler, found := s.routes[r.Method+" "+r.URL.Path]
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	handler(w, r)
+}
+
+// startStub starts a server for the stub API, closed when the test ends, and
+// resets the plugin config to query it as the service type.
+func startStub(t *testing.T, serviceType string, stub *stubApi) *url.URL {
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	serverUrl, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverUrl.Port())
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.Type = serviceType
+	plugin.Scheme = "http"
+	plugin.Host = serverUrl.Hostname()
+	plugin.Port = port
+	return serverUrl
+}
+
 func TestTLSArguments(t *testing.T) {
 	plugin = Config{
 		PluginConfig: sensu.PluginConfig{
@@ -322,3 +403,203 @@ func TestMultipleEval(t *testing.T) {
 		}
 	})
 }
+
+func TestSeverityEval(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"usage": 90}`)
+	}))
+	defer server.Close()
+	tests := []struct {
+		name            string
+		eval_statements []string
+		warning_evals   []string
+		critical_evals  []string
+		eval_status     int
+		expected_status int
+	}{
+		{
+			name:            "all conditions met",
+			warning_evals:   []string{"result.usage < 95"},
+			critical_evals:  []string{"result.usage < 99"},
+			eval_status:     1,
+			expected_status: sensu.CheckStateOK,
+		},
+		{
+			name:            "warning condition not met",
+			warning_evals:   []string{"result.usage < 80"},
+			critical_evals:  []string{"result.usage < 95"},
+			eval_status:     1,
+			expected_status: sensu.CheckStateWarning,
+		},
+		{
+			name:            "critical condition not met",
+			warning_evals:   []string{"result.usage < 80"},
+			critical_evals:  []string{"result.usage < 85"},
+			eval_status:     1,
+			expected_status: sensu.CheckStateCritical,
+		},
+		{
+			name:            "eval evaluated before warning",
+			eval_statements: []string{"result.usage < 50"},
+			warning_evals:   []string{"result.usage < 80"},
+			eval_status:     3,
+			expected_status: sensu.CheckStateUnknown,
+		},
+		{
+			name:            "critical evaluated before eval",
+			eval_statements: []string{"result.usage < 50"},
+			critical_evals:  []string{"result.usage < 85"},
+			eval_status:     1,
+			expected_status: sensu.CheckStateCritical,
+		},
+		{
+			name:            "critical evaluated before unknown eval",
+			eval_statements: []string{"result.usage < 50"},
+			critical_evals:  []string{"result.usage < 85"},
+			eval_status:     3,
+			expected_status: sensu.CheckStateCritical,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin = Config{
+				PluginConfig: sensu.PluginConfig{
+					Name:  "test",
+					Short: "test",
+				},
+			}
+			plugin.Url = server.URL
+			plugin.Request = `GET`
+			plugin.EvalStatus = tt.eval_status
+			plugin.EvalStatements = tt.eval_statements
+			plugin.WarningEvals = tt.warning_evals
+			plugin.CriticalEvals = tt.critical_evals
+			status, err := executeCheck(nil)
+			if err != nil {
+				t.Errorf("executeCheck(nil) unexpected err: %v", err)
+				return
+			}
+			if status != tt.expected_status {
+				t.Errorf("executeCheck(nil) status: %v expected: %v", status, tt.expected_status)
+				return
+			}
+		})
+	}
+}
+
+func TestSeverityArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		status   int
+	}{
+		{"below thresholds", `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1600000000, "60"]}]}}`, sensu.CheckStateOK},
+		{"warning threshold", `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1600000000, "85"]}]}}`, sensu.CheckStateWarning},
+		{"critical threshold", `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1600000000, "97"]}]}}`, sensu.CheckStateCritical},
+		{"empty result", `{"status": "success", "data": {"resultType": "vector", "result": []}}`, sensu.CheckStateCritical},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.response)
+			}))
+			defer server.Close()
+
+			// the disk usage example of the README
+			output, err := runCheck(t,
+				"--url", server.URL+"/api/v1/query",
+				"--query", "query=max(disk_used_percent)",
+				"--warning-eval", "result.data.result[0].value[1] < 80",
+				"--critical-eval", "!!result.data.result[0] && result.data.result[0].value[1] < 95",
+			)
+			status := sensu.CheckStateOK
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				status = exitErr.ExitCode()
+			} else if err != nil {
+				t.Fatalf("runCheck() err: %v output: %s", err, output)
+			}
+			if status != tt.status {
+				t.Errorf("runCheck() status: %v expected: %v output: %s", status, tt.status, output)
+			}
+		})
+	}
+}
+
+func TestSharedSandbox(t *testing.T) {
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.EvalStatus = 1
+	plugin.EvalStatements = []string{
+		`var values = result.data.result.map(r => parseFloat(r.value[1])); values.length === 2`,
+		`Math.max(...values) < 0.9`,
+	}
+	vm, err := newSandbox(`{"data": {"result": [{"value": [0, "0.42"]}, {"value": [0, "0.87"]}]}}`, nil)
+	if err != nil {
+		t.Errorf("newSandbox() err: %v", err)
+		return
+	}
+	status, output, err := evaluate(vm)
+	if status != sensu.CheckStateOK || err != nil {
+		t.Errorf("evaluate() status: %v output: %v err: %v", status, output, err)
+	}
+	if _, err := newSandbox(`not json`, nil); err == nil {
+		t.Errorf("newSandbox() expected err for invalid JSON")
+	}
+}
+
+// benchmarkStatements are evaluated against a multi-megabyte Prometheus
+// range query response by BenchmarkEvalStatements
+var benchmarkStatements = []string{
+	`result.status === "success"`,
+	`result.data.resultType === "matrix"`,
+	`result.data.result.length > 0`,
+	`result.data.result.every(r => r.values.length > 0)`,
+	`result.data.result[0].metric.job === "node"`,
+	`result.data.result.filter(r => r.metric.instance.startsWith("web")).length > 10`,
+	`parseFloat(result.data.result[0].values[0][1]) < 1`,
+	`result.data.result.every(r => parseFloat(r.values[r.values.length - 1][1]) < 1)`,
+	`result.data.result.map(r => r.values.length).reduce((a, b) => a + b, 0) > 1000`,
+	`!!result.data.result.find(r => r.metric.instance === "web-42:9100")`,
+}
+
+func BenchmarkEvalStatements(b *testing.B) {
+	response := prometheusMatrix(100, 2000)
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.EvalStatus = 1
+	plugin.EvalStatements = benchmarkStatements
+	// Validating the body and parsing it into a new sandbox for every statement
+	b.Run("sandbox per statement", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var body interface{}
+			if err := json.Unmarshal([]byte(response), &body); err != nil {
+				b.Fatal(err)
+			}
+			for _, eval := range benchmarkStatements {
+				if result, err := processResponse(response, eval); err != nil || !result {
+					b.Fatalf("processResponse() %v result: %v err: %v", eval, result, err)
+				}
+			}
+		}
+	})
+	// Parsing the body once into a sandbox shared by all statements
+	b.Run("shared sandbox", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			vm, err := newSandbox(response, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if status, output, err := evaluate(vm); status != sensu.CheckStateOK || err != nil {
+				b.Fatalf("evaluate() status: %v output: %v err: %v", status, output, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricFormats maps the supported --metric-format values (named after the
+// Sensu check output_metric_format values) to their formatting function.
+var metricFormats = map[string]func(points []metricPoint) []string{
+	"graphite_plaintext": graphiteMetrics,
+	"influxdb_line":      influxMetrics,
+	"opentsdb_line":      opentsdbMetrics,
+	"prometheus_text":    prometheusMetrics,
+	"nagios_perfdata":    nagiosMetrics,
+}
+
+type metricPoint struct {
+	name      string
+	value     float64
+	tags      map[string]string
+	timestamp time.Time
+}
+
+// parseNameValue splits a "name=value" argument, such as a --metric or
+// --metric-tag.
+func parseNameValue(argument string) (string, string, error) {
+	split := strings.SplitN(argument, "=", 2)
+	if len(split) != 2 || len(strings.TrimSpace(split[0])) == 0 || len(strings.TrimSpace(split[1])) == 0 {
+		return "", "", fmt.Errorf("expected name=value: %q", argument)
+	}
+	return strings.TrimSpace(split[0]), strings.TrimSpace(split[1]), nil
+}
+
+// extractMetrics evaluates the --metric expressions in the sandbox.
+func extractMetrics(vm evaluator) ([]metricPoint, error) {
+	tags := map[string]string{}
+	for _, tag := range plugin.MetricTags {
+		key, value, err := parseNameValue(tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --metric-tag: %v", err)
+		}
+		tags[key] = value
+	}
+	now := time.Now()
+	points := []metricPoint{}
+	for _, metric := range plugin.Metrics {
+		name, expression, err := parseNameValue(metric)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --metric: %v", err)
+		}
+		value, err := vm.Run(expression)
+		if err != nil {
+			return nil, fmt.Errorf("could not evaluate metric %s: %w", name, err)
+		}
+		number := value.ToFloat()
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, fmt.Errorf("metric %s did not return a number: %v", name, value)
+		}
+		points = append(points, metricPoint{
+			name:      name,
+			value:     number,
+			tags:      tags,
+			timestamp: now,
+		})
+	}
+	return points, nil
+}
+
+// appendMetrics adds the metric points to the check output in the
+// --metric-format.
+func appendMetrics(output string, points []metricPoint) string {
+	if len(points) == 0 {
+		return output
+	}
+	lines := metricFormats[plugin.MetricFormat](points)
+	if plugin.MetricFormat == "nagios_perfdata" {
+		return fmt.Sprintf("%s | %s", output, strings.Join(lines, " "))
+	}
+	return fmt.Sprintf("%s\n%s", output, strings.Join(lines, "\n"))
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// sortedTags returns the tags as key=value pairs, sorted by key.
+func sortedTags(tags map[string]string, quote bool) []string {
+	pairs := []string{}
+	for key, value := range tags {
+		if quote {
+			value = strconv.Quote(value)
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// graphiteMetrics formats points as "name;tag=value value timestamp"
+func graphiteMetrics(points []metricPoint) []string {
+	lines := []string{}
+	for _, point := range points {
+		name := strings.Join(append([]string{point.name}, sortedTags(point.tags, false)...), ";")
+		lines = append(lines, fmt.Sprintf("%s %s %d", name, formatValue(point.value), point.timestamp.Unix()))
+	}
+	return lines
+}
+
+// influxMetrics formats points as "name,tag=value value=value timestamp"
+func influxMetrics(points []metricPoint) []string {
+	lines := []string{}
+	for _, point := range points {
+		name := strings.Join(append([]string{point.name}, sortedTags(point.tags, false)...), ",")
+		lines = append(lines, fmt.Sprintf("%s value=%s %d", name, formatValue(point.value), point.timestamp.UnixNano()))
+	}
+	return lines
+}
+
+// opentsdbMetrics formats points as "name timestamp value tag=value"
+func opentsdbMetrics(points []metricPoint) []string {
+	lines := []string{}
+	for _, point := range points {
+		line := fmt.Sprintf("%s %d %s", point.name, point.timestamp.Unix(), formatValue(point.value))
+		if len(point.tags) > 0 {
+			line = fmt.Sprintf("%s %s", line, strings.Join(sortedTags(point.tags, false), " "))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// prometheusMetrics formats points as `name{tag="value"} value timestamp`
+func prometheusMetrics(points []metricPoint) []string {
+	lines := []string{}
+	for _, point := range points {
+		name := point.name
+		if len(point.tags) > 0 {
+			name = fmt.Sprintf("%s{%s}", name, strings.Join(sortedTags(point.tags, true), ","))
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %d", name, formatValue(point.value), point.timestamp.UnixNano()/int64(time.Millisecond)))
+	}
+	return lines
+}
+
+// nagiosMetrics formats points as "name=value" perfdata, tags are not
+// supported by the format
+func nagiosMetrics(points []metricPoint) []string {
+	lines := []string{}
+	for _, point := range points {
+		lines = append(lines, fmt.Sprintf("%s=%s", point.name, formatValue(point.value)))
+	}
+	return lines
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricFormats(t *testing.T) {
+	timestamp := time.Unix(1600000000, 0)
+	points := []metricPoint{
+		{
+			name:      "api_latency",
+			value:     0.25,
+			tags:      map[string]string{"cluster": "prod-eu", "app": "api"},
+			timestamp: timestamp,
+		},
+		{
+			name:      "api_requests",
+			value:     1200,
+			tags:      map[string]string{},
+			timestamp: timestamp,
+		},
+	}
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{
+			format:   "graphite_plaintext",
+			expected: "ok\napi_latency;app=api;cluster=prod-eu 0.25 1600000000\napi_requests 1200 1600000000",
+		},
+		{
+			format:   "influxdb_line",
+			expected: "ok\napi_latency,app=api,cluster=prod-eu value=0.25 1600000000000000000\napi_requests value=1200 1600000000000000000",
+		},
+		{
+			format:   "opentsdb_line",
+			expected: "ok\napi_latency 1600000000 0.25 app=api cluster=prod-eu\napi_requests 1600000000 1200",
+		},
+		{
+			format:   "prometheus_text",
+			expected: "ok\napi_latency{app=\"api\",cluster=\"prod-eu\"} 0.25 1600000000000\napi_requests 1200 1600000000000",
+		},
+		{
+			format:   "nagios_perfdata",
+			expected: "ok | api_latency=0.25 api_requests=1200",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			plugin.MetricFormat = tt.format
+			output := appendMetrics("ok", points)
+			if output != tt.expected {
+				t.Errorf("appendMetrics() output:\n%v\nexpected:\n%v", output, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExtractMetrics(t *testing.T) {
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	response := `{"data": {"result": [{"value": [1600000000, "0.812"]}]}}`
+	tests := []struct {
+		name          string
+		metrics       []string
+		expect_error  bool
+		expected_name string
+		expected      float64
+	}{
+		{
+			name:          "string value",
+			metrics:       []string{"p95_latency=result.data.result[0].value[1]"},
+			expected_name: "p95_latency",
+			expected:      0.812,
+		},
+		{
+			name:          "computed value",
+			metrics:       []string{"p95_latency_ms = result.data.result[0].value[1] * 1000"},
+			expected_name: "p95_latency_ms",
+			expected:      812,
+		},
+		{
+			name:         "not a number",
+			metrics:      []string{"status=result.data"},
+			expect_error: true,
+		},
+		{
+			name:         "missing expression",
+			metrics:      []string{"status"},
+			expect_error: true,
+		},
+		{
+			name:          "expression with commas",
+			metrics:       []string{"p95_latency=Math.max(result.data.result[0].value[1], 0.5)"},
+			expected_name: "p95_latency",
+			expected:      0.812,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin.Metrics = tt.metrics
+			plugin.MetricTags = []string{"cluster=prod-eu"}
+			vm, err := newSandbox(response, nil)
+			if err != nil {
+				t.Errorf("newSandbox() err: %v", err)
+				return
+			}
+			points, err := extractMetrics(vm)
+			if tt.expect_error != (err != nil) {
+				t.Errorf("extractMetrics() expect_error: %v, err: %v", tt.expect_error, err)
+				return
+			}
+			if tt.expect_error {
+				return
+			}
+			if len(points) != 1 || points[0].name != tt.expected_name || points[0].value != tt.expected || points[0].tags["cluster"] != "prod-eu" {
+				t.Errorf("extractMetrics() unexpected points: %v", points)
+			}
+		})
+	}
+}
+
+func TestMetricsArgs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"values": [[1600000000, 10], [1600000060, 20], [1600000120, 30]]}`)
+	}))
+	defer server.Close()
+
+	// quotes and commas are not split like a list of values
+	output, err := runCheck(t,
+		"--url", server.URL,
+		"--metric", "p50=stats.percentile(result.values, 50)",
+		"--metric", "last=stats.last(result.values)",
+		"--metric", `quoted=result["values"].length`,
+		"--metric-format", "graphite_plaintext",
+	)
+	if err != nil {
+		t.Fatalf("runCheck() err: %v output: %s", err, output)
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	expected := []string{"p50 20 ", "last 30 ", "quoted 3 "}
+	if len(lines) < len(expected) {
+		t.Fatalf("runCheck() output: %s", output)
+	}
+	metrics := lines[len(lines)-len(expected):]
+	for i, prefix := range expected {
+		if !strings.HasPrefix(metrics[i], prefix) {
+			t.Errorf("runCheck() metric %d: %q, expected %q", i, metrics[i], prefix)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// outlierMethods maps the --outliers methods to functions returning the
+// score of each value of a peer group, and whether it is an outlier.
+var outlierMethods = map[string]func(values []float64, sensitivity float64) ([]float64, []bool){
+	"mad":    madOutliers,
+	"iqr":    iqrOutliers,
+	"dbscan": dbscanOutliers,
+}
+
+// minPeerGroup is the number of series a peer group needs for its series to
+// be compared. Series in smaller groups are never outliers.
+const minPeerGroup = 3
+
+// peerDefaults are the --outlier-series, --outlier-value and --outlier-label
+// defaults by service type.
+var peerDefaults = map[string]struct {
+	series string
+	value  string
+	label  string
+}{
+	"prometheus": {"result.data.result", "stats.last(peer.values || [peer.value])", "{{ .metric.instance }}"},
+	"influxdb":   {"result.results[0].series", "stats.last(peer.values)", "{{ .tags.host }}"},
+	"influxdb2":  {"result.tables", "stats.last(peer.values)", "{{ .group.host }}"},
+	"graphite":   {"result", "stats.last(peer.values)", "{{ .target }}"},
+	"cloudwatch": {"result.results", "stats.last(peer.values)", "{{ .label }}"},
+}
+
+// peerSeries is a series of the --outlier-series array, scored against the
+// median of its peer group.
+type peerSeries struct {
+	label   string
+	group   string
+	value   float64
+	median  float64
+	score   float64
+	outlier bool
+}
+
+// vars returns the series as a sandbox and template object.
+func (s peerSeries) vars() map[string]interface{} {
+	return map[string]interface{}{
+		"label":  s.label,
+		"group":  s.group,
+		"value":  s.value,
+		"median": s.median,
+		"score":  s.score,
+	}
+}
+
+// outliersMessage returns the check output of the outliers of count series.
+func outliersMessage(outliers []peerSeries, count int) string {
+	labels := []string{}
+	for _, outlier := range outliers {
+		labels = append(labels, fmt.Sprintf("%s (%.4g, group median %.4g, score %.2f)", outlier.label, outlier.value, outlier.median, outlier.score))
+	}
+	return fmt.Sprintf("%d of %d series are outliers (%s): %s", len(outliers), count, plugin.Outliers, strings.Join(labels, ", "))
+}
+
+// detectOutliers scores the value of each --outlier-series element against
+// the other series of its --outlier-group, and seeds the outliers into the
+// sandbox as outliers. It returns the outliers and the number of series.
+func detectOutliers(vm evaluator) ([]peerSeries, int, error) {
+	labelTemplate, err := template.New("label").Option("missingkey=error").Parse(plugin.OutlierLabel)
+	if err != nil {
+		return nil, 0, err
+	}
+	groupTemplate, err := template.New("group").Option("missingkey=error").Parse(plugin.OutlierGroup)
+	if err != nil {
+		return nil, 0, err
+	}
+	value, err := vm.Run(fmt.Sprintf("__peers = (%s); Array.isArray(__peers) ? __peers.length : -1", plugin.OutlierSeries))
+	if err != nil {
+		return nil, 0, fmt.Errorf("--outlier-series: %w", err)
+	}
+	count := int(value.ToFloat())
+	if count < 0 {
+		return nil, 0, fmt.Errorf("--outlier-series %s did not return an array", plugin.OutlierSeries)
+	}
+
+	peers := []peerSeries{}
+	groups := map[string][]int{}
+	for i := 0; i < count; i++ {
+		element, err := vm.Run(fmt.Sprintf("peer = __peers[%d]", i))
+		if err != nil {
+			return nil, 0, err
+		}
+		var label, group bytes.Buffer
+		if err := labelTemplate.Execute(&label, element.Export()); err != nil {
+			return nil, 0, fmt.Errorf("--outlier-label for series %d: %v", i, err)
+		}
+		if err := groupTemplate.Execute(&group, element.Export()); err != nil {
+			return nil, 0, fmt.Errorf("--outlier-group for series %d: %v", i, err)
+		}
+		value, err := vm.Run(plugin.OutlierValue)
+		if err != nil {
+			return nil, 0, fmt.Errorf("--outlier-value for series %d: %w", i, err)
+		}
+		number, ok := exportedNumber(value.Export())
+		if !ok || math.IsNaN(number) {
+			// series without a value are not compared
+			continue
+		}
+		peer := peerSeries{
+			label:  strings.TrimSpace(label.String()),
+			group:  strings.TrimSpace(group.String()),
+			value:  number,
+			median: math.NaN(),
+			score:  math.NaN(),
+		}
+		groups[peer.group] = append(groups[peer.group], len(peers))
+		peers = append(peers, peer)
+	}
+
+	for _, members := range groups {
+		if len(members) < minPeerGroup {
+			continue
+		}
+		values := make([]float64, len(members))
+		for i, member := range members {
+			values[i] = peers[member].value
+		}
+		center := median(values)
+		scores, outliers := outlierMethods[plugin.Outliers](values, plugin.Sensitivity)
+		for i, member := range members {
+			peers[member].median = center
+			peers[member].score = scores[i]
+			peers[member].outlier = outliers[i]
+		}
+	}
+
+	outliers := []peerSeries{}
+	objects := []interface{}{}
+	for _, peer := range peers {
+		if peer.outlier {
+			outliers = append(outliers, peer)
+			objects = append(objects, peer.vars())
+		}
+	}
+	if plugin.Debug {
+		fmt.Printf("Outliers: %v of %d series\n", objects, count)
+	}
+	return outliers, count, vm.Set("outliers", objects)
+}
+
+// madOutliers scores values by their distance to the median in median
+// absolute deviations, scaled to estimate the standard deviation of normal
+// data. Values over sensitivity away are outliers.
+func madOutliers(values []float64, sensitivity float64) ([]float64, []bool) {
+	center, spread := madBand(values)
+	scores := make([]float64, len(values))
+	outliers := make([]bool, len(values))
+	for i, v := range values {
+		scores[i] = anomalyScore(v, center, spread)
+		outliers[i] = math.Abs(scores[i]) > sensitivity
+	}
+	return scores, outliers
+}
+
+// iqrOutliers scores values by their distance to the median in interquartile
+// ranges. Values over sensitivity interquartile ranges below the first or
+// above the third quartile are outliers (Tukey's fences).
+func iqrOutliers(values []float64, sensitivity float64) ([]float64, []bool) {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	q1, q3 := percentile(sorted, 25), percentile(sorted, 75)
+	center, iqr := median(values), q3-q1
+	scores := make([]float64, len(values))
+	outliers := make([]bool, len(values))
+	for i, v := range values {
+		scores[i] = anomalyScore(v, center, iqr)
+		outliers[i] = v < q1-sensitivity*iqr || v > q3+sensitivity*iqr
+	}
+	return scores, outliers
+}
+
+// dbscanOutliers clusters values like DBSCAN in one dimension: values within
+// sensitivity scaled median absolute deviations of each other are
+// neighbours, and chains of neighbours form a cluster. Values outside of the
+// largest cluster are outliers, scored by their distance to it.
+func dbscanOutliers(values []float64, sensitivity float64) ([]float64, []bool) {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return values[order[i]] < values[order[j]]
+	})
+	_, spread := madBand(values)
+	eps := sensitivity * spread
+
+	// clusters are runs of sorted values separated by at most eps
+	cluster := make([]int, len(values))
+	sizes := []int{1}
+	for i := 1; i < len(order); i++ {
+		if values[order[i]]-values[order[i-1]] > eps {
+			sizes = append(sizes, 0)
+		}
+		cluster[order[i]] = len(sizes) - 1
+		sizes[len(sizes)-1]++
+	}
+	largest := 0
+	for i, size := range sizes {
+		if size > sizes[largest] {
+			largest = i
+		}
+	}
+	low, high := math.Inf(1), math.Inf(-1)
+	for i, v := range values {
+		if cluster[i] == largest {
+			low, high = math.Min(low, v), math.Max(high, v)
+		}
+	}
+
+	scores := make([]float64, len(values))
+	outliers := make([]bool, len(values))
+	for i, v := range values {
+		switch {
+		case v < low:
+			scores[i] = anomalyScore(v, low, spread)
+		case v > high:
+			scores[i] = anomalyScore(v, high, spread)
+		}
+		outliers[i] = cluster[i] != largest
+	}
+	return scores, outliers
+}
+
+// percentile returns the p-th percentile of sorted values, interpolating
+// between the closest ranks like stats.percentile.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestOutliers(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("test")))
+	defer server.Close()
+
+	tests := []struct {
+		fixture        string
+		service_type   string
+		outliers       string
+		outlier_series string
+		outlier_value  string
+		outlier_label  string
+		outlier_group  string
+		evals          []string
+		status         int
+		output         string
+	}{
+		// all series are peers, so the batch jobs are outliers too
+		{"outliers-prometheus.json", "prometheus", "mad", "", "", "", "", nil, sensu.CheckStateWarning, "5 of 14 series are outliers (mad): web-7 (180, group median 102, score 15.03), batch-1 (500, group median 102, score 76.70), batch-2 (510, group median 102, score 78.63), batch-3 (495, group median 102, score 75.74), batch-4 (505, group median 102, score 77.66)"},
+		{"outliers-prometheus.json", "prometheus", "mad", "", "", "", "{{ .metric.job }}", nil, sensu.CheckStateWarning, "1 of 14 series are outliers (mad): web-7 (180, group median 100.5, score 35.75)"},
+		{"outliers-prometheus.json", "prometheus", "iqr", "", "", "", "{{ .metric.job }}", nil, sensu.CheckStateWarning, "1 of 14 series are outliers (iqr): web-7 (180, group median 100.5, score 28.91)"},
+		{"outliers-prometheus.json", "prometheus", "dbscan", "", "", "", "{{ .metric.job }}", nil, sensu.CheckStateWarning, "1 of 14 series are outliers (dbscan): web-7 (180, group median 100.5, score 34.62)"},
+		{"outliers-prometheus.json", "prometheus", "mad", "", "", "{{ .metric.instance }} ({{ .metric.job }})", "{{ .metric.job }}", []string{`outliers.length === 1 && outliers[0].label === "web-7 (api)" && outliers[0].group === "api"`}, sensu.CheckStateWarning, "1 of 14 series are outliers (mad): web-7 (api) (180, group median 100.5, score 35.75)"},
+		// the last value of each series, ignoring nulls
+		{"outliers-influxdb.json", "influxdb", "mad", "", "", "", "", nil, sensu.CheckStateWarning, "1 of 6 series are outliers (mad): app-6 (91, group median 41.5, score 22.26)"},
+		{"outliers-elasticsearch.json", "elasticsearch", "mad", "result.aggregations.hosts.buckets", "peer.latency.value", "{{ .key }}", "", nil, sensu.CheckStateWarning, "1 of 6 series are outliers (mad): es-4 (2.1, group median 12.85, score -24.17)"},
+		{"outliers-elasticsearch.json", "elasticsearch", "mad", "result.aggregations.hosts.buckets", "peer.doc_count", "{{ .key }}", "", nil, sensu.CheckStateOK, "All eval conditions were met."},
+		// series in groups of less than 3 are never outliers
+		{"outliers-prometheus.json", "prometheus", "mad", "", "", "", "{{ .metric.instance }}", nil, sensu.CheckStateOK, "All eval conditions were met."},
+	}
+	for _, tt := range tests {
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.Url = server.URL + "/" + tt.fixture
+		plugin.Type = tt.service_type
+		plugin.EvalStatus = 1
+		plugin.Outliers = tt.outliers
+		plugin.OutlierSeries = tt.outlier_series
+		plugin.OutlierValue = tt.outlier_value
+		plugin.OutlierLabel = tt.outlier_label
+		plugin.OutlierGroup = tt.outlier_group
+		plugin.Sensitivity = 3
+		plugin.EvalStatements = tt.evals
+		plugin.OutputTemplate = "{{ .check.output }}"
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			continue
+		}
+		vm, err := newSandbox(string(mustQuery(t, plugin.Url)), nil)
+		if err != nil {
+			t.Errorf("newSandbox() unexpected err: %v", err)
+			continue
+		}
+		status, output, err := evaluate(vm)
+		if err == nil {
+			output, err = renderOutput(vm, status, output)
+		}
+		if status != tt.status || output != tt.output || err != nil {
+			t.Errorf("evaluate() %s %s group %q status: %v output: %q err: %v", tt.fixture, tt.outliers, tt.outlier_group, status, output, err)
+		}
+	}
+
+	plugin = Config{}
+	plugin.Url = server.URL + "/outliers-prometheus.json"
+	plugin.EvalStatus = 1
+	plugin.Outliers = "mad"
+	plugin.Sensitivity = 3
+	if status, err := checkArgs(nil); status != sensu.CheckStateWarning || err == nil {
+		t.Errorf("checkArgs(nil) expected missing --outlier-series, status: %v err: %v", status, err)
+	}
+	plugin.Type = "prometheus"
+	plugin.OutlierLabel = "{{ .metric.hostname }}"
+	if _, err := checkArgs(nil); err != nil {
+		t.Errorf("checkArgs(nil) unexpected err: %v", err)
+	}
+	vm, err := newSandbox(string(mustQuery(t, plugin.Url)), nil)
+	if err == nil {
+		_, _, err = evaluate(vm)
+	}
+	if err == nil {
+		t.Errorf("evaluate() expected missing label err")
+	}
+}
+
+func TestOutlierMethods(t *testing.T) {
+	values := []float64{10, 11, 12, 11, 10, 30, 11, -5}
+	tests := []struct {
+		method   string
+		outliers []bool
+	}{
+		{"mad", []bool{false, false, false, false, false, true, false, true}},
+		{"iqr", []bool{false, false, false, false, false, true, false, true}},
+		{"dbscan", []bool{false, false, false, false, false, true, false, true}},
+	}
+	for _, tt := range tests {
+		_, outliers := outlierMethods[tt.method](values, 3)
+		if !reflect.DeepEqual(outliers, tt.outliers) {
+			t.Errorf("%s outliers: %v", tt.method, outliers)
+		}
+	}
+
+	// two clusters: the smaller one is the outlier
+	_, outliers := dbscanOutliers([]float64{1, 2, 3, 100, 101, 2, 1}, 3)
+	if !reflect.DeepEqual(outliers, []bool{false, false, false, true, true, false, false}) {
+		t.Errorf("dbscanOutliers() clusters: %v", outliers)
+	}
+	if p := percentile([]float64{2, 4, 4, 4, 5, 5, 7, 9}, 25); p != 4 {
+		t.Errorf("percentile() = %v", p)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// isGoTemplate reports whether the --output-template is a Go template,
+// otherwise it is rendered as a Javascript template literal.
+func isGoTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// renderOutput renders the --output-template, if any, against the response,
+// the current series (with --per-series), the --anomaly score, the --forecast,
+// the --outliers, and the check status and output of the eval statements,
+// available as check.status and check.output.
+func renderOutput(vm evaluator, status int, output string) (string, error) {
+	if len(plugin.OutputTemplate) == 0 {
+		return output, nil
+	}
+	check := map[string]interface{}{
+		"status": status,
+		"output": output,
+	}
+	if !isGoTemplate(plugin.OutputTemplate) {
+		if err := vm.Set("check", check); err != nil {
+			return "", err
+		}
+		value, err := vm.Run(fmt.Sprintf("`%s`", plugin.OutputTemplate))
+		if err != nil {
+			return "", fmt.Errorf("could not render output template: %w", err)
+		}
+		return strings.TrimSpace(value.String()), nil
+	}
+
+	data := map[string]interface{}{
+		"check": check,
+	}
+	names := []string{"result"}
+	if len(plugin.PerSeries) > 0 {
+		names = append(names, "series")
+	}
+	if len(plugin.Anomaly) > 0 {
+		names = append(names, "anomaly")
+	}
+	if len(plugin.Forecast) > 0 {
+		names = append(names, "forecast")
+	}
+	if len(plugin.Outliers) > 0 {
+		names = append(names, "outliers")
+	}
+	for _, name := range names {
+		value, err := vm.Run(name)
+		if err != nil {
+			return "", err
+		}
+		data[name] = value.Export()
+	}
+	outputTemplate, err := template.New("output").Parse(plugin.OutputTemplate)
+	if err != nil {
+		return "", fmt.Errorf("could not parse output template: %v", err)
+	}
+	var rendered bytes.Buffer
+	if err := outputTemplate.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("could not render output template: %v", err)
+	}
+	return strings.TrimSpace(rendered.String()), nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"testing"
+)
+
+func TestRenderOutput(t *testing.T) {
+	data := `{"cluster": "prod-eu", "latency": {"p95": 812, "p99": 1020}, "hosts": ["web-1", "web-2"]}`
+	tests := []struct {
+		output_template string
+		status          int
+		output          string
+		expected        string
+		has_err         bool
+	}{
+		{"", sensu.CheckStateOK, "All eval conditions were met.", "All eval conditions were met.", false},
+		{
+			`api latency p95 is {{ .result.latency.p95 }}ms (threshold 500ms) on cluster {{ .result.cluster }}`,
+			sensu.CheckStateWarning, `An eval condition was not met: "result.latency.p95 < 500" (false)`,
+			"api latency p95 is 812ms (threshold 500ms) on cluster prod-eu", false,
+		},
+		{
+			"api latency p95 is ${result.latency.p95}ms (threshold 500ms) on cluster ${result.cluster}",
+			sensu.CheckStateWarning, `An eval condition was not met: "result.latency.p95 < 500" (false)`,
+			"api latency p95 is 812ms (threshold 500ms) on cluster prod-eu", false,
+		},
+		{
+			`{{ if eq .check.status 0 }}ok{{ else }}status {{ .check.status }}: {{ .check.output }}{{ end }} ({{ len .result.hosts }} hosts)`,
+			sensu.CheckStateCritical, "p99 too high",
+			"status 2: p99 too high (2 hosts)", false,
+		},
+		{
+			"${check.status === 0 ? 'ok' : check.output} on ${result.hosts.join(', ')}",
+			sensu.CheckStateCritical, "p99 too high",
+			"p99 too high on web-1, web-2", false,
+		},
+		{"${result.missing.path}", sensu.CheckStateOK, "", "", true},
+		{"{{ .result.cluster.name }}", sensu.CheckStateOK, "", "", true},
+	}
+	for _, tt := range tests {
+		plugin = Config{}
+		plugin.OutputTemplate = tt.output_template
+		vm, err := newSandbox(data, nil)
+		if err != nil {
+			t.Errorf("newSandbox() unexpected err: %v", err)
+			continue
+		}
+		output, err := renderOutput(vm, tt.status, tt.output)
+		if output != tt.expected || (err != nil) != tt.has_err {
+			t.Errorf("renderOutput() template: %q output: %q err: %v", tt.output_template, output, err)
+		}
+	}
+}
+
+func TestRenderSeriesOutput(t *testing.T) {
+	plugin = Config{}
+	plugin.PerSeries = `result.data.result`
+	for _, outputTemplate := range []string{
+		`{{ .series.metric.instance }} p95 is {{ index .series.value 1 }}s`,
+		"${series.metric.instance} p95 is ${series.value[1]}s",
+	} {
+		plugin.OutputTemplate = outputTemplate
+		vm, err := newSandbox(`{"data": {"result": [{"metric": {"instance": "web-1"}, "value": [1600000000, "0.81"]}]}}`, nil)
+		if err != nil {
+			t.Errorf("newSandbox() unexpected err: %v", err)
+			continue
+		}
+		if _, err := vm.Run("series = result.data.result[0]"); err != nil {
+			t.Errorf("vm.Run() unexpected err: %v", err)
+			continue
+		}
+		output, err := renderOutput(vm, sensu.CheckStateWarning, "")
+		if output != "web-1 p95 is 0.81s" || err != nil {
+			t.Errorf("renderOutput() template: %q output: %q err: %v", outputTemplate, output, err)
+		}
+	}
+}
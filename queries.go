@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sensu/sensu-go/types"
+)
+
+// queryRequest is a query sent by the check: the --query, the baseline
+// query, or one of the --named-queries.
+type queryRequest struct {
+	name        string
+	serviceType string
+	url         string
+	method      string
+	headers     []string
+	query       string
+	// bucket of --type=influxdb2 queries
+	bucket string
+	// reference time of the time macros, for the default time window of
+	// --type=cloudwatch and --type=sumologic queries
+	now time.Time
+}
+
+// namedQuery is the JSON definition of one of the --named-queries, using
+// the names of the flags it overrides.
+type namedQuery struct {
+	Type    string   `json:"type"`
+	Url     string   `json:"url"`
+	Scheme  string   `json:"scheme"`
+	Host    string   `json:"host"`
+	Port    int      `json:"port"`
+	Path    string   `json:"path"`
+	Params  string   `json:"params"`
+	Index   string   `json:"index"`
+	Bucket  string   `json:"bucket"`
+	Request string   `json:"request"`
+	Headers []string `json:"header"`
+	Query   string   `json:"query"`
+}
+
+// prepareRequest returns the request for the query configured by configure
+// on top of the plugin config, with its templates and URL rendered like the
+// --query. The plugin config is left as it was, so it can be rendered next.
+func prepareRequest(name string, configure func()) (queryRequest, error) {
+	// all requests share the reference time of the time macros
+	templateNow()
+	config := plugin
+	defer func() {
+		plugin = config
+	}()
+	configure()
+	if err := applyEventTemplates(plugin.event); err != nil {
+		return queryRequest{}, err
+	}
+	url, err := finalUrl()
+	if err != nil {
+		return queryRequest{}, err
+	}
+	method := strings.ToUpper(plugin.Request)
+	if len(method) == 0 {
+		method = "GET"
+	}
+	return queryRequest{
+		name:        name,
+		serviceType: plugin.Type,
+		url:         url,
+		method:      method,
+		headers:     plugin.Headers,
+		query:       plugin.Query,
+		bucket:      plugin.Bucket,
+		now:         plugin.now,
+	}, nil
+}
+
+// decodeObject calls decode with the name and value of each member of the
+// JSON object, in the order they are defined. Names must be unique and not
+// empty.
+func decodeObject(data string, decode func(name string, value json.RawMessage) error) error {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return fmt.Errorf("expected a JSON object")
+	}
+	names := map[string]bool{}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		name := token.(string)
+		if len(strings.TrimSpace(name)) == 0 {
+			return fmt.Errorf("names can't be empty")
+		}
+		if names[name] {
+			return fmt.Errorf("duplicate name %q", name)
+		}
+		names[name] = true
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if err := decode(name, value); err != nil {
+			return err
+		}
+	}
+	_, err := decoder.Token()
+	return err
+}
+
+// namedRequests returns the requests of the --named-queries definitions, a
+// JSON object of query definitions by name, in the order they are defined.
+func namedRequests() ([]queryRequest, error) {
+	requests := []queryRequest{}
+	if len(strings.TrimSpace(plugin.NamedQueries)) == 0 {
+		return requests, nil
+	}
+	err := decodeObject(plugin.NamedQueries, func(name string, spec json.RawMessage) error {
+		var query namedQuery
+		decoder := json.NewDecoder(bytes.NewReader(spec))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&query); err != nil {
+			return fmt.Errorf("query %s: %v", name, err)
+		}
+		request, err := prepareRequest(name, func() {
+			plugin.Type = query.Type
+			plugin.Url = query.Url
+			plugin.Scheme = query.Scheme
+			plugin.Host = query.Host
+			plugin.Port = query.Port
+			plugin.ApiPath = query.Path
+			plugin.ApiParams = query.Params
+			plugin.Index = query.Index
+			plugin.Bucket = query.Bucket
+			plugin.Request = query.Request
+			plugin.Headers = query.Headers
+			plugin.Query = query.Query
+		})
+		if err != nil {
+			return fmt.Errorf("query %s: %v", name, err)
+		}
+		requests = append(requests, request)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// runCheckQueries runs the --query, the baseline query and the named queries
+// concurrently. It returns the --query response (null if there is none), and
+// the other responses and the event as sandbox variables.
+func runCheckQueries(event *types.Event) ([]byte, map[string]interface{}, error) {
+	requests := []queryRequest{}
+	mainQuery, baselineQuery := -1, -1
+	if len(plugin.Url) > 0 {
+		mainQuery = len(requests)
+		requests = append(requests, queryRequest{
+			serviceType: plugin.Type,
+			url:         plugin.Url,
+			method:      plugin.Request,
+			headers:     plugin.Headers,
+			query:       plugin.Query,
+			bucket:      plugin.Bucket,
+		})
+	}
+	if plugin.baseline != nil {
+		baselineQuery = len(requests)
+		requests = append(requests, *plugin.baseline)
+	}
+	requests = append(requests, plugin.namedQueries...)
+	responses, errs := runQueries(requests)
+
+	response := []byte("null")
+	vars := map[string]interface{}{"event": event}
+	results := map[string]json.RawMessage{}
+	for i, request := range requests {
+		if plugin.Debug {
+			fmt.Printf("%shttp response: %v\n", strings.TrimPrefix(request.name+" ", " "), string(responses[i]))
+		}
+		if errs[i] != nil {
+			err := errs[i]
+			if len(request.name) > 0 {
+				err = fmt.Errorf("%s query: %w", request.name, err)
+			}
+			fmt.Printf("Error attempting query http request: %v\n", err)
+			return nil, nil, err
+		}
+		switch i {
+		case mainQuery:
+			response = responses[i]
+		case baselineQuery:
+			vars["baseline"] = json.RawMessage(responses[i])
+		default:
+			results[request.name] = json.RawMessage(responses[i])
+		}
+	}
+	if len(plugin.namedQueries) > 0 {
+		vars["results"] = results
+	}
+	return response, vars, nil
+}
+
+// runQueries runs the requests concurrently and returns their responses and
+// errors, in the order of the requests.
+func runQueries(requests []queryRequest) ([][]byte, []error) {
+	responses := make([][]byte, len(requests))
+	errs := make([]error, len(requests))
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		wg.Add(1)
+		go func(i int, request queryRequest) {
+			defer wg.Done()
+			responses[i], errs[i] = runRequest(request)
+		}(i, request)
+	}
+	wg.Wait()
+	return responses, errs
+}
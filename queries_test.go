@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNamedQueries(t *testing.T) {
+	// both servers wait for each other's request, so the queries only
+	// complete if they run concurrently
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	wait := func() bool {
+		arrived.Done()
+		done := make(chan struct{})
+		go func() {
+			arrived.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return true
+		case <-time.After(5 * time.Second):
+			return false
+		}
+	}
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !wait() {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1600000000, "2000"]}]}}`)
+	}))
+	defer prometheus.Close()
+	var authorization string
+	var search map[string]interface{}
+	elasticsearch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &search)
+		if !wait() || r.URL.Path != "/logs-*/_search" {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		fmt.Fprint(w, `{"hits": {"total": {"value": 50, "relation": "eq"}, "hits": []}}`)
+	}))
+	defer elasticsearch.Close()
+
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.EvalStatus = 2
+	plugin.NamedQueries = fmt.Sprintf(`{
+		"requests": {"type": "prometheus", "url": "%s/api/v1/query", "query": "query=sum(increase(http_requests_total[5m]))"},
+		"errors": {"type": "elasticsearch", "url": "%s/logs-*/_search", "header": ["Authorization: ApiKey c2Vuc3U="], "query": "{\"query\": {\"match\": {\"level\": \"error\"}}, \"size\": 0}"}
+	}`, prometheus.URL, elasticsearch.URL)
+	plugin.EvalStatements = []string{
+		`result === null`,
+		`results.errors.hits.total.value / results.requests.data.result[0].value[1] < 0.05`,
+	}
+	if _, err := checkArgs(nil); err != nil {
+		t.Errorf("checkArgs(nil) unexpected err: %v", err)
+		return
+	}
+	status, err := executeCheck(nil)
+	if status != sensu.CheckStateOK || err != nil {
+		t.Errorf("executeCheck(nil) status: %v err: %v", status, err)
+	}
+	if authorization != "ApiKey c2Vuc3U=" || search["size"] != float64(0) {
+		t.Errorf("executeCheck(nil) elasticsearch authorization: %v search: %v", authorization, search)
+	}
+
+	// the converted response of a named query is held to the
+	// --max-response-size too, even when the annotated CSV is smaller
+	rows := strings.Repeat(",,0,2020-09-13T12:00:00Z,2020-09-13T12:30:00Z,2020-09-13T12:10:00Z,41.5,usage_user,cpu,web-1\n", 8000)
+	if len(rows) > 1024*1024 {
+		t.Fatalf("annotated CSV of %d bytes exceeds the limit", len(rows))
+	}
+	stub := &influxd{response: strings.SplitN(fluxResponse, ",,0,", 2)[0] + rows}
+	influxdb := startStub(t, "", stub.api())
+	plugin.EvalStatus = 2
+	plugin.MaxResponseSize = 1
+	plugin.NamedQueries = fmt.Sprintf(`{"cpu": {"type": "influxdb2", "url": "%s/api/v2/query?org=ops", "bucket": "telegraf", "query": "from(bucket: bucket) |> range(start: -30m)"}}`, influxdb)
+	plugin.EvalStatements = []string{`results.cpu.tables.length > 0`}
+	if _, err := checkArgs(nil); err != nil {
+		t.Fatalf("checkArgs(nil) unexpected err: %v", err)
+	}
+	if status, err := executeCheck(nil); status != sensu.CheckStateUnknown || err == nil || !strings.Contains(err.Error(), "cpu query") {
+		t.Errorf("executeCheck(nil) expected named query response size err, status: %v err: %v", status, err)
+	}
+
+	invalid := []string{
+		`requests`,
+		`[{"type": "prometheus"}]`,
+		`{"requests": {"type": "prometheus"}`,
+		`{"requests": {"type": "prometheus", "target": "up"}}`,
+		`{"requests": {"query": "query=up"}}`,
+		`{"": {"type": "prometheus"}}`,
+		`{"requests": {"type": "prometheus"}, "requests": {"type": "prometheus"}}`,
+	}
+	for _, definitions := range invalid {
+		plugin = Config{}
+		plugin.EvalStatus = 1
+		plugin.NamedQueries = definitions
+		if status, err := checkArgs(nil); status != sensu.CheckStateWarning || err == nil {
+			t.Errorf("checkArgs(nil) named queries: %v status: %v err: %v", plugin.NamedQueries, status, err)
+		}
+	}
+}
+
+func TestNamedQueriesArgs(t *testing.T) {
+	queries := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.FormValue("query")
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1600000000, "1"]}]}}`)
+	}))
+	defer server.Close()
+
+	// quotes and commas are not split like a list of values
+	output, err := runCheck(t,
+		"--named-queries", fmt.Sprintf(`{"up": {"type": "prometheus", "url": "%[1]s/api/v1/query", "query": "query=min(up{job=\"api\"})"}, "ready": {"type": "prometheus", "url": "%[1]s/api/v1/query", "query": "query=min by (job, instance) (ready)"}}`, server.URL),
+		"--eval", "results.up.data.result[0].value[1] == 1 && results.ready.data.result[0].value[1] == 1",
+	)
+	if err != nil {
+		t.Fatalf("runCheck() err: %v output: %s", err, output)
+	}
+	received := map[string]bool{<-queries: true, <-queries: true}
+	if !received[`min(up{job="api"})`] || !received["min by (job, instance) (ready)"] {
+		t.Errorf("runCheck() queries: %v output: %s", received, output)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// jsFile is a Javascript file loaded with --eval-file or --library.
+type jsFile struct {
+	path   string
+	source string
+}
+
+// evalFileScript runs an --eval-file and returns its verdict: the function
+// assigned to module.exports or returned by the last expression is called
+// with result, otherwise the value of the last expression is the verdict.
+// The source is evaluated in the global scope, like an --eval statement.
+const evalFileScript = `var module = {exports: undefined};
+var __verdict = (0, eval)(%s);
+if (typeof module.exports === "function") {
+  __verdict = module.exports;
+}
+typeof __verdict === "function" ? __verdict(result) : __verdict`
+
+// systemPrefixes are PATH prefixes that are never runtime assets, so files
+// below them (e.g. /usr/lib) can't be loaded as relative scripts.
+var systemPrefixes = map[string]bool{"/": true, "/usr": true, "/usr/local": true}
+
+// assetDirs returns the root directory of each Sensu runtime asset, which the
+// agent adds to the PATH of the check as <asset>/bin. The agent installs each
+// asset in a cache directory named after its SHA-512, so other PATH entries
+// ending in bin (e.g. /usr/bin) are ignored.
+func assetDirs() []string {
+	dirs := []string{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if filepath.Base(dir) != "bin" {
+			continue
+		}
+		root := filepath.Dir(filepath.Clean(dir))
+		if systemPrefixes[filepath.ToSlash(root)] || !isSha512(filepath.Base(root)) {
+			continue
+		}
+		dirs = append(dirs, root)
+	}
+	return dirs
+}
+
+// isSha512 returns true if name is a hex encoded SHA-512 digest.
+func isSha512(name string) bool {
+	if len(name) != sha512.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// resolveScript returns the path of an --eval-file or --library file.
+// Relative paths are resolved against the current directory first, then
+// against each runtime asset directory.
+func resolveScript(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return path, nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	for _, dir := range assetDirs() {
+		candidate := filepath.Join(dir, path)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in the current directory or runtime asset directories", path)
+}
+
+// loadScripts resolves and reads each of the Javascript files.
+func loadScripts(paths []string) ([]jsFile, error) {
+	files := []jsFile{}
+	for _, path := range paths {
+		resolved, err := resolveScript(path)
+		if err != nil {
+			return nil, err
+		}
+		if plugin.Debug {
+			fmt.Printf("Loading script %s from %s\n", path, resolved)
+		}
+		source, err := ioutil.ReadFile(resolved)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, jsFile{path: path, source: string(source)})
+	}
+	return files, nil
+}
+
+// evalFileStatements returns the eval statements running the --eval-file
+// files, named after their path in the check output.
+func evalFileStatements(files []jsFile) ([]evalStatement, error) {
+	statements := []evalStatement{}
+	for _, file := range files {
+		source, err := json.Marshal(file.source)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, evalStatement{
+			name:   file.path,
+			script: fmt.Sprintf(evalFileScript, source),
+		})
+	}
+	return statements, nil
+}
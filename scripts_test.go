@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEvalFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"latency": {"p50": 120, "p99": 420}}`)
+	}))
+	defer server.Close()
+
+	// runtime asset layout: <asset>/bin is on the PATH
+	asset := assetDir(t)
+	if err := os.MkdirAll(filepath.Join(asset, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(asset, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"lib/latency.js":  "function slow(latency, threshold) {\n  return latency.p99 > threshold;\n}\n",
+		"expression.js":   "// final expression\nvar p99 = result.latency.p99;\np99 < 500\n",
+		"exports.js":      "module.exports = function(result) {\n  return !slow(result.latency, 400);\n};\n",
+		"function.js":     "(function(result) {\n  return result.latency.p50 < 200;\n})\n",
+		"syntax-error.js": "result.latency.(\n",
+		"loop.js":         "while (true) {}\n",
+	}
+	for name, source := range files {
+		if err := ioutil.WriteFile(filepath.Join(asset, name), []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", filepath.Join(asset, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		eval_files []string
+		libraries  []string
+		status     int
+		has_err    bool
+	}{
+		{[]string{"expression.js"}, []string{}, sensu.CheckStateOK, false},
+		{[]string{"function.js"}, []string{}, sensu.CheckStateOK, false},
+		{[]string{"expression.js", "exports.js"}, []string{"lib/latency.js"}, sensu.CheckStateWarning, false},
+		{[]string{filepath.Join(asset, "function.js")}, []string{filepath.Join(asset, "lib/latency.js")}, sensu.CheckStateOK, false},
+		{[]string{"exports.js"}, []string{}, sensu.CheckStateCritical, true},
+		{[]string{"syntax-error.js"}, []string{}, sensu.CheckStateCritical, true},
+	}
+	for _, tt := range tests {
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.Url = server.URL
+		plugin.EvalStatus = 1
+		plugin.EvalFiles = tt.eval_files
+		plugin.Libraries = tt.libraries
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			continue
+		}
+		status, err := executeCheck(nil)
+		if status != tt.status || (err != nil) != tt.has_err {
+			t.Errorf("executeCheck(nil) eval files: %v status: %v err: %v", tt.eval_files, status, err)
+		}
+	}
+
+	// timed out eval files are reported by path, not by their wrapper script
+	plugin.EvalFiles = []string{"loop.js"}
+	plugin.Libraries = []string{}
+	plugin.EvalTimeout = 1
+	if _, err := checkArgs(nil); err != nil {
+		t.Fatalf("checkArgs(nil) unexpected err: %v", err)
+	}
+	status, err := executeCheck(nil)
+	if status != sensu.CheckStateUnknown || err == nil || err.Error() != "loop.js: eval statement exceeded the 1s eval timeout" {
+		t.Errorf("executeCheck(nil) timed out eval file status: %v err: %v", status, err)
+	}
+}
+
+// assetDir returns a runtime asset directory named after its SHA-512, like
+// the agent's asset cache.
+func assetDir(t *testing.T) string {
+	digest := sha512.Sum512([]byte(t.Name()))
+	asset := filepath.Join(t.TempDir(), hex.EncodeToString(digest[:]))
+	if err := os.MkdirAll(filepath.Join(asset, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return asset
+}
+
+func TestResolveScript(t *testing.T) {
+	asset := assetDir(t)
+	if err := ioutil.WriteFile(filepath.Join(asset, "check.js"), []byte("true"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// directories ending in bin that aren't runtime assets are not searched
+	other := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(other, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(other, "other.js"), []byte("true"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", strings.Join([]string{"/usr/bin", "/bin", "/usr/local/bin", filepath.Join(other, "bin"), filepath.Join(asset, "bin")}, string(os.PathListSeparator)))
+
+	if dirs := assetDirs(); len(dirs) != 1 || dirs[0] != asset {
+		t.Errorf("assetDirs() = %v", dirs)
+	}
+	if _, err := resolveScript("lib/os-release"); err == nil {
+		t.Errorf("resolveScript() expected err for file below /usr")
+	}
+	if _, err := resolveScript("other.js"); err == nil {
+		t.Errorf("resolveScript() expected err for file outside runtime assets")
+	}
+	path, err := resolveScript("check.js")
+	if err != nil || path != filepath.Join(asset, "check.js") {
+		t.Errorf("resolveScript() path: %v err: %v", path, err)
+	}
+	if _, err := resolveScript("missing.js"); err == nil {
+		t.Errorf("resolveScript() expected err for missing file")
+	}
+	plugin = Config{}
+	plugin.Url = "http://localhost"
+	plugin.EvalStatus = 1
+	plugin.Libraries = []string{"missing.js"}
+	if status, err := checkArgs(nil); status != sensu.CheckStateWarning || err == nil {
+		t.Errorf("checkArgs(nil) status: %v err: %v", status, err)
+	}
+}
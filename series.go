@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-go/types"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// executeSeriesCheck evaluates the eval statements once per element of the
+// --per-series array and sends the result of each to the agent events API,
+// as an event for a proxy entity named by the --entity-template. The check
+// itself only fails if the series can't be evaluated or sent.
+func executeSeriesCheck(event *types.Event, vm evaluator) (int, error) {
+	count, err := selectSeries(vm)
+	if err != nil {
+		fmt.Printf("Error attempting to evaluate --per-series expression: %v\n", err)
+		return errorStatus(err), err
+	}
+	entityTemplate, err := template.New("entity").Option("missingkey=error").Parse(plugin.EntityTemplate)
+	if err != nil {
+		return sensu.CheckStateCritical, err
+	}
+
+	counts := map[int]int{}
+	for i := 0; i < count; i++ {
+		element, err := vm.Run(fmt.Sprintf("series = __series[%d]", i))
+		if err != nil {
+			return sensu.CheckStateCritical, err
+		}
+		var name bytes.Buffer
+		if err := entityTemplate.Execute(&name, element.Export()); err != nil {
+			fmt.Printf("Error attempting to name proxy entity for series %d: %v\n", i, err)
+			return sensu.CheckStateCritical, err
+		}
+		entityName := strings.TrimSpace(name.String())
+		if err := corev2.ValidateName(entityName); err != nil {
+			err = fmt.Errorf("proxy entity name %q for series %d %v", entityName, i, err)
+			fmt.Printf("Error attempting to name proxy entity: %v\n", err)
+			return sensu.CheckStateCritical, err
+		}
+
+		status, output, err := evaluate(vm)
+		if err == nil {
+			output, err = renderOutput(vm, status, output)
+		}
+		if err != nil {
+			status = errorStatus(err)
+			output = fmt.Sprintf("Error attempting to evaluate series: %v", err)
+		}
+		if plugin.Verbose {
+			fmt.Printf("%s: %s\n", entityName, output)
+		}
+		if err := postEvent(seriesEvent(event, entityName, status, output)); err != nil {
+			fmt.Printf("Error sending event for proxy entity %s: %v\n", entityName, err)
+			return sensu.CheckStateCritical, err
+		}
+		counts[status]++
+	}
+	fmt.Printf("Evaluated %d series: %d ok, %d warning, %d critical, %d unknown\n",
+		count,
+		counts[sensu.CheckStateOK],
+		counts[sensu.CheckStateWarning],
+		counts[sensu.CheckStateCritical],
+		count-counts[sensu.CheckStateOK]-counts[sensu.CheckStateWarning]-counts[sensu.CheckStateCritical])
+	return sensu.CheckStateOK, nil
+}
+
+// selectSeries evaluates the --per-series expression in the sandbox and
+// returns the number of series. Each series is then selected as series.
+func selectSeries(vm evaluator) (int, error) {
+	value, err := vm.Run(fmt.Sprintf("__series = (%s); Array.isArray(__series) ? __series.length : -1", plugin.PerSeries))
+	if err != nil {
+		return 0, err
+	}
+	count := int(value.ToFloat())
+	if count < 0 {
+		return 0, fmt.Errorf("%s did not return an array", plugin.PerSeries)
+	}
+	return count, nil
+}
+
+// seriesEvent returns the event for a single series and its proxy entity.
+func seriesEvent(event *types.Event, entityName string, status int, output string) *types.Event {
+	seriesEvent := &types.Event{
+		Timestamp: time.Now().Unix(),
+		Entity: &types.Entity{
+			ObjectMeta: corev2.ObjectMeta{
+				Name: entityName,
+			},
+			EntityClass: corev2.EntityProxyClass,
+		},
+		Check: &types.Check{
+			ObjectMeta: corev2.ObjectMeta{
+				Name: plugin.CheckName,
+			},
+			Status:   uint32(status),
+			Output:   output,
+			Handlers: plugin.Handlers,
+		},
+	}
+	if event != nil && event.Entity != nil {
+		seriesEvent.Entity.Namespace = event.Entity.Namespace
+		seriesEvent.Check.Namespace = event.Entity.Namespace
+	}
+	return seriesEvent
+}
+
+// postEvent sends the event to the agent events API.
+func postEvent(event *types.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Timeout: time.Duration(plugin.Timeout) * time.Second,
+	}
+	resp, err := client.Post(plugin.EventsApi, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("events API returned %v: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
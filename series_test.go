@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-go/types"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestSeriesCheck(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [
+			{"metric": {"instance": "web-1", "job": "node"}, "value": [1600000000, "0.42"]},
+			{"metric": {"instance": "web-2", "job": "node"}, "value": [1600000000, "0.87"]},
+			{"metric": {"instance": "web-3", "job": "node"}, "value": [1600000000, "0.97"]}
+		]}}`)
+	}))
+	defer prometheus.Close()
+	var lock sync.Mutex
+	events := map[string]*types.Event{}
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &types.Event{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		events[event.Entity.Name] = event
+		lock.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer agent.Close()
+
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.Url = prometheus.URL
+	plugin.Request = `GET`
+	plugin.EvalStatus = 1
+	plugin.PerSeries = `result.data.result`
+	plugin.EntityTemplate = `{{ .metric.instance }}`
+	plugin.CheckName = `cpu-usage`
+	plugin.Handlers = []string{"pagerduty"}
+	plugin.EventsApi = agent.URL
+	plugin.WarningEvals = []string{`series.value[1] < 0.8`}
+	plugin.CriticalEvals = []string{`series.value[1] < 0.95`}
+	if _, err := checkArgs(nil); err != nil {
+		t.Errorf("checkArgs(nil) unexpected err: %v", err)
+		return
+	}
+	status, err := executeCheck(nil)
+	if status != sensu.CheckStateOK || err != nil {
+		t.Errorf("executeCheck(nil) status: %v err: %v", status, err)
+		return
+	}
+	expected := map[string]uint32{
+		"web-1": sensu.CheckStateOK,
+		"web-2": sensu.CheckStateWarning,
+		"web-3": sensu.CheckStateCritical,
+	}
+	if len(events) != len(expected) {
+		t.Errorf("executeCheck(nil) sent %d events, expected %d", len(events), len(expected))
+		return
+	}
+	for entity, expected_status := range expected {
+		event, found := events[entity]
+		if !found {
+			t.Errorf("executeCheck(nil) no event sent for entity %v", entity)
+			continue
+		}
+		if event.Check.Status != expected_status {
+			t.Errorf("entity %v status: %v expected: %v (%v)", entity, event.Check.Status, expected_status, event.Check.Output)
+		}
+		if event.Entity.EntityClass != "proxy" || event.Check.Name != "cpu-usage" || len(event.Check.Handlers) != 1 {
+			t.Errorf("entity %v unexpected event: %v", entity, event)
+		}
+	}
+
+	t.Run("missing label", func(t *testing.T) {
+		plugin.EntityTemplate = `{{ .metric.hostname }}`
+		status, err := executeCheck(nil)
+		if status != sensu.CheckStateCritical || err == nil {
+			t.Errorf("executeCheck(nil) status: %v err: %v", status, err)
+		}
+	})
+	t.Run("missing check name", func(t *testing.T) {
+		plugin.CheckName = ``
+		if _, err := checkArgs(nil); err == nil {
+			t.Errorf("checkArgs(nil) expected err")
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// Initial and maximum delay between search job status requests
+	splunkPollInterval    = 250 * time.Millisecond
+	splunkMaxPollInterval = 5 * time.Second
+)
+
+type splunkJob struct {
+	Sid string `json:"sid"`
+}
+
+type splunkJobStatus struct {
+	Entry []struct {
+		Content struct {
+			IsDone        bool   `json:"isDone"`
+			IsFailed      bool   `json:"isFailed"`
+			DispatchState string `json:"dispatchState"`
+			Messages      []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"messages"`
+		} `json:"content"`
+	} `json:"entry"`
+}
+
+// splunkQuery runs the query as a Splunk search job: the job is created via
+// the search/jobs endpoint (the request url), polled with backoff until it is
+// done, and its results are returned. Jobs that don't finish within --timeout
+// are cancelled.
+func splunkQuery(request queryRequest) ([]byte, error) {
+	urlString := request.url
+	deadline := time.Now().Add(time.Duration(plugin.Timeout) * time.Second)
+	body, err := doRequest(urlString, request.method, request.headers, strings.NewReader(request.query))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create Splunk search job: %v", err)
+	}
+	var job splunkJob
+	if err := json.Unmarshal(body, &job); err != nil || len(job.Sid) == 0 {
+		return body, fmt.Errorf("Splunk search job response did not include a sid: %s", string(body))
+	}
+	if plugin.Debug {
+		fmt.Printf("Created Splunk search job: %v\n", job.Sid)
+	}
+
+	jobUrl, err := splunkJobUrl(urlString, job.Sid, "")
+	if err != nil {
+		return nil, err
+	}
+	wait := splunkPollInterval
+	for {
+		body, err = doRequest(jobUrl, "GET", request.headers, nil)
+		if err != nil {
+			splunkCancelJob(request, job.Sid)
+			return nil, fmt.Errorf("Could not get Splunk search job %v status: %v", job.Sid, err)
+		}
+		var status splunkJobStatus
+		if err := json.Unmarshal(body, &status); err != nil || len(status.Entry) == 0 {
+			splunkCancelJob(request, job.Sid)
+			return body, fmt.Errorf("Unexpected Splunk search job %v status: %s", job.Sid, string(body))
+		}
+		content := status.Entry[0].Content
+		if plugin.Debug {
+			fmt.Printf("Splunk search job %v dispatch state: %v\n", job.Sid, content.DispatchState)
+		}
+		if content.IsFailed || content.DispatchState == "FAILED" {
+			messages := []string{}
+			for _, message := range content.Messages {
+				messages = append(messages, fmt.Sprintf("%v: %v", message.Type, message.Text))
+			}
+			return body, fmt.Errorf("Splunk search job %v failed: %v", job.Sid, strings.Join(messages, "; "))
+		}
+		if content.IsDone {
+			break
+		}
+		if time.Now().Add(wait).After(deadline) {
+			splunkCancelJob(request, job.Sid)
+			return nil, fmt.Errorf("Splunk search job %v did not complete within %v seconds", job.Sid, plugin.Timeout)
+		}
+		time.Sleep(wait)
+		wait *= 2
+		if wait > splunkMaxPollInterval {
+			wait = splunkMaxPollInterval
+		}
+	}
+
+	resultsUrl, err := splunkJobUrl(urlString, job.Sid, "results")
+	if err != nil {
+		return nil, err
+	}
+	return doRequest(resultsUrl, "GET", request.headers, nil)
+}
+
+// splunkCancelJob asks Splunk to cancel the search job. Errors are only
+// reported since the check fails regardless.
+func splunkCancelJob(request queryRequest, sid string) {
+	controlUrl, err := splunkJobUrl(request.url, sid, "control")
+	if err == nil {
+		_, err = doRequest(controlUrl, "POST", request.headers, strings.NewReader("action=cancel"))
+	}
+	if err != nil && plugin.Verbose {
+		fmt.Printf("Could not cancel Splunk search job %v: %v\n", sid, err)
+	}
+}
+
+// splunkJobUrl returns the url of the search job sid (or one of its
+// endpoints) below the search/jobs url, keeping its query parameters.
+func splunkJobUrl(urlString string, sid string, endpoint string) (string, error) {
+	jobUrl, err := url.Parse(urlString)
+	if err != nil {
+		return "", err
+	}
+	jobUrl.Path = fmt.Sprintf("%v/%v", strings.TrimSuffix(jobUrl.Path, "/"), url.PathEscape(sid))
+	if len(endpoint) > 0 {
+		jobUrl.Path = fmt.Sprintf("%v/%v", jobUrl.Path, endpoint)
+	}
+	if endpoint == "results" {
+		params := jobUrl.Query()
+		params.Set("count", "0")
+		jobUrl.RawQuery = params.Encode()
+	}
+	return jobUrl.String(), nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// splunkd is the state of the search job of a Splunk REST API stub, which
+// is done after a number of polls, or fails.
+type splunkd struct {
+	polls     int
+	doneAfter int
+	failed    bool
+	cancelled bool
+	search    string
+}
+
+func (s *splunkd) api() *stubApi {
+	return &stubApi{
+		before: func(w http.ResponseWriter, r *http.Request) bool {
+			if r.URL.Query().Get("output_mode") != "json" {
+				w.WriteHeader(http.StatusBadRequest)
+				return false
+			}
+			return true
+		},
+		routes: map[string]http.HandlerFunc{
+			"POST /services/search/jobs": func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				s.search = string(body)
+				fmt.Fprint(w, `{"sid": "1600000000.42"}`)
+			},
+			"GET /services/search/jobs/1600000000.42": func(w http.ResponseWriter, r *http.Request) {
+				s.polls++
+				state := "RUNNING"
+				if s.failed {
+					state = "FAILED"
+				} else if s.doneAfter > 0 && s.polls >= s.doneAfter {
+					state = "DONE"
+				}
+				fmt.Fprintf(w, `{"entry": [{"content": {"isDone": %v, "isFailed": %v, "dispatchState": "%v", "messages": [{"type": "FATAL", "text": "bad search"}]}}]}`,
+					state == "DONE", s.failed, state)
+			},
+			"GET /services/search/jobs/1600000000.42/results": func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("count") != "0" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				fmt.Fprint(w, `{"results": [{"count": "17"}]}`)
+			},
+			"POST /services/search/jobs/1600000000.42/control": func(w http.ResponseWriter, r *http.Request) {
+				s.cancelled = true
+				fmt.Fprint(w, `{"messages": [{"type": "INFO", "text": "Search job cancelled."}]}`)
+			},
+		},
+	}
+}
+
+func TestSplunkQuery(t *testing.T) {
+	splunkPollInterval = 10 * time.Millisecond
+	tests := []struct {
+		name              string
+		done_after        int
+		failed            bool
+		expect_error      bool
+		expect_cancelled  bool
+		expected_response string
+	}{
+		{
+			name:              "job completes",
+			done_after:        3,
+			expected_response: `{"results": [{"count": "17"}]}`,
+		},
+		{
+			name:             "job fails",
+			failed:           true,
+			expect_error:     true,
+			expect_cancelled: false,
+		},
+		{
+			name:             "job times out",
+			done_after:       0,
+			expect_error:     true,
+			expect_cancelled: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &splunkd{doneAfter: tt.done_after, failed: tt.failed}
+			serverUrl := startStub(t, "splunk", stub.api())
+			plugin.Timeout = 1
+			plugin.Url = serverUrl.String() + "/services/search/jobs?output_mode=json"
+			plugin.Request = "POST"
+			response, err := runQuery(plugin.Url, plugin.Request, "search=search index=main error | stats count")
+			if tt.expect_error != (err != nil) {
+				t.Errorf("runQuery() expect_error: %v, err: %v\n", tt.expect_error, err)
+				return
+			}
+			if !tt.expect_error && string(response) != tt.expected_response {
+				t.Errorf("runQuery() response: %s, expected: %s\n", response, tt.expected_response)
+				return
+			}
+			if stub.cancelled != tt.expect_cancelled {
+				t.Errorf("runQuery() cancelled: %v, expected: %v\n", stub.cancelled, tt.expect_cancelled)
+				return
+			}
+			if !strings.HasPrefix(stub.search, "search=search index=main") {
+				t.Errorf("runQuery() unexpected search job body: %v\n", stub.search)
+				return
+			}
+		})
+	}
+}
+
+func TestSplunkUrl(t *testing.T) {
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.Type = "splunk"
+	url, err := finalUrl()
+	if err != nil {
+		t.Errorf("finalUrl() Unexpected return err: %v\n", err)
+		return
+	}
+	if url != `https://localhost:8089/services/search/jobs?output_mode=json` {
+		t.Errorf("finalUrl() Unexpected return url: %v\n", url)
+		return
+	}
+	url, err = splunkJobUrl(url, "1600000000.42", "results")
+	if err != nil {
+		t.Errorf("splunkJobUrl() Unexpected return err: %v\n", err)
+		return
+	}
+	if url != `https://localhost:8089/services/search/jobs/1600000000.42/results?count=0&output_mode=json` {
+		t.Errorf("splunkJobUrl() Unexpected return url: %v\n", url)
+		return
+	}
+}
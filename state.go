@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sensu/sensu-go/types"
+
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// checkState is the content of a --state-dir state file.
+type checkState struct {
+	// Previous is the previousRun of the last check execution
+	Previous json.RawMessage `json:"previous"`
+	// State is the state object as left by the eval statements
+	State json.RawMessage `json:"state"`
+}
+
+// previousRun is available to eval statements as previous.
+type previousRun struct {
+	Timestamp int64              `json:"timestamp"`
+	Status    int                `json:"status"`
+	Output    string             `json:"output"`
+	Result    json.RawMessage    `json:"result"`
+	Metrics   map[string]float64 `json:"metrics"`
+}
+
+// statePath returns the path of the state file of the check, named after
+// --check-name or the name of the check in the event.
+func statePath(event *types.Event) (string, error) {
+	name := plugin.CheckName
+	if len(name) == 0 && event != nil && event.Check != nil {
+		name = event.Check.Name
+	}
+	if len(name) == 0 {
+		return "", fmt.Errorf("--state-dir requires --check-name, or the check in the event (stdin: true)")
+	}
+	if err := corev2.ValidateName(name); err != nil {
+		return "", fmt.Errorf("check name %q %v", name, err)
+	}
+	return filepath.Join(plugin.StateDir, name+".json"), nil
+}
+
+// loadState returns the state of the last check execution. A missing or
+// invalid state file is an empty state, so the check starts over.
+func loadState(path string) checkState {
+	state := checkState{
+		Previous: json.RawMessage("null"),
+		State:    json.RawMessage("{}"),
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) && plugin.Verbose {
+			fmt.Printf("Could not read state file %s: %v\n", path, err)
+		}
+		return state
+	}
+	var saved checkState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		if plugin.Verbose {
+			fmt.Printf("Ignoring invalid state file %s: %v\n", path, err)
+		}
+		return state
+	}
+	if len(saved.Previous) > 0 {
+		state.Previous = saved.Previous
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(saved.State), []byte("{")) {
+		state.State = saved.State
+	}
+	return state
+}
+
+// saveState saves the state object of the sandbox and the results of this
+// check execution to the state file. The query response is only saved up to
+// the --state-result-size, since the file is rewritten on every execution.
+// The file is replaced atomically, so concurrent check executions never read
+// a partially written state.
+func saveState(path string, vm evaluator, response []byte, status int, output string, points []metricPoint) error {
+	value, err := vm.Run("JSON.stringify(state)")
+	if err != nil {
+		return err
+	}
+	state := json.RawMessage("{}")
+	if text, ok := value.Export().(string); ok && len(text) > 0 {
+		state = json.RawMessage(text)
+	}
+	metrics := map[string]float64{}
+	for _, point := range points {
+		metrics[point.name] = point.value
+	}
+	result := json.RawMessage(response)
+	if plugin.StateResultSize > 0 && len(response) > plugin.StateResultSize*1024 {
+		if plugin.Verbose {
+			fmt.Printf("Not saving the query response (%d bytes) in the state file, it exceeds the --state-result-size of %d KB\n", len(response), plugin.StateResultSize)
+		}
+		result = json.RawMessage("null")
+	}
+	previous, err := json.Marshal(previousRun{
+		Timestamp: time.Now().Unix(),
+		Status:    status,
+		Output:    output,
+		Result:    result,
+		Metrics:   metrics,
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(checkState{Previous: previous, State: state})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path
+// and renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStateBetweenRuns(t *testing.T) {
+	value := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"value": %d}`, value)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+
+	tests := []struct {
+		value  int
+		status int
+	}{
+		{10, sensu.CheckStateOK},
+		{20, sensu.CheckStateOK},
+		{30, sensu.CheckStateWarning},
+		{20, sensu.CheckStateOK},
+		{100, sensu.CheckStateCritical},
+	}
+	for i, tt := range tests {
+		value = tt.value
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.Url = server.URL
+		plugin.EvalStatus = 1
+		plugin.StateDir = dir
+		plugin.CheckName = "api-requests"
+		plugin.Metrics = []string{"requests=result.value"}
+		plugin.MetricFormat = "graphite_plaintext"
+		plugin.CriticalEvals = []string{`state.runs = (state.runs || 0) + 1; previous === null || result.value <= previous.result.value * 2`}
+		plugin.EvalStatements = []string{
+			`state.rises = previous !== null && result.value > previous.metrics.requests ? (state.rises || 0) + 1 : 0; state.rises < 2`,
+		}
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			return
+		}
+		status, err := executeCheck(nil)
+		if status != tt.status || err != nil {
+			t.Errorf("executeCheck(nil) run %d status: %v err: %v", i+1, status, err)
+		}
+	}
+
+	var state checkState
+	data, err := ioutil.ReadFile(filepath.Join(dir, "api-requests.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+	if err != nil || string(state.State) != `{"runs":5,"rises":0}` {
+		t.Errorf("state file: %s err: %v", data, err)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"invalid.json":  `{"previous": {"status": 1}, "state": `,
+		"no-state.json": `{"previous": {"status": 1}, "state": null}`,
+		"valid.json":    `{"previous": {"status": 1}, "state": {"runs": 2}}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		file     string
+		previous string
+		state    string
+	}{
+		{"missing.json", `null`, `{}`},
+		{"invalid.json", `null`, `{}`},
+		{"no-state.json", `{"status": 1}`, `{}`},
+		{"valid.json", `{"status": 1}`, `{"runs": 2}`},
+	}
+	for _, tt := range tests {
+		state := loadState(filepath.Join(dir, tt.file))
+		if string(state.Previous) != tt.previous || string(state.State) != tt.state {
+			t.Errorf("loadState(%s) previous: %s state: %s", tt.file, state.Previous, state.State)
+		}
+	}
+}
+
+func TestSaveStateResultSize(t *testing.T) {
+	dir := t.TempDir()
+	large := fmt.Sprintf(`{"values":[%s0]}`, strings.Repeat("0,", 1024))
+	tests := []struct {
+		name     string
+		size     int
+		response string
+		expected string
+	}{
+		{"below the limit", 1, `{"value":1}`, `{"value":1}`},
+		{"above the limit", 1, large, `null`},
+		{"no limit", 0, large, large},
+	}
+	for _, tt := range tests {
+		plugin = Config{}
+		plugin.StateResultSize = tt.size
+		vm, err := newSandbox(tt.response, nil)
+		if err == nil {
+			err = vm.Set("state", map[string]interface{}{"runs": 1})
+		}
+		path := filepath.Join(dir, "check.json")
+		if err == nil {
+			err = saveState(path, vm, []byte(tt.response), sensu.CheckStateWarning, "output", []metricPoint{{name: "requests", value: 2}})
+		}
+		if err != nil {
+			t.Errorf("saveState() %s err: %v", tt.name, err)
+			continue
+		}
+		var previous struct {
+			Status  int                `json:"status"`
+			Output  string             `json:"output"`
+			Result  json.RawMessage    `json:"result"`
+			Metrics map[string]float64 `json:"metrics"`
+		}
+		state := loadState(path)
+		if err := json.Unmarshal(state.Previous, &previous); err != nil {
+			t.Errorf("saveState() %s previous: %s err: %v", tt.name, state.Previous, err)
+			continue
+		}
+		if string(previous.Result) != tt.expected || previous.Status != sensu.CheckStateWarning || previous.Output != "output" || previous.Metrics["requests"] != 2 || string(state.State) != `{"runs":1}` {
+			t.Errorf("saveState() %s previous: %s state: %s", tt.name, state.Previous, state.State)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "check.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			data, _ := json.Marshal(map[string]interface{}{"writer": i, "padding": make([]int, 10000)})
+			if err := writeFileAtomic(path, data); err != nil {
+				t.Errorf("writeFileAtomic() err: %v", err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			data, err := ioutil.ReadFile(path)
+			if os.IsNotExist(err) {
+				return
+			}
+			var state map[string]interface{}
+			if err := json.Unmarshal(data, &state); err != nil {
+				t.Errorf("read partially written state file: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	files, _ := ioutil.ReadDir(filepath.Dir(path))
+	if len(files) != 1 {
+		t.Errorf("writeFileAtomic() left temporary files: %v", len(files))
+	}
+}
+
+func TestStateCheckName(t *testing.T) {
+	plugin = Config{}
+	plugin.Url = "http://localhost"
+	plugin.EvalStatus = 1
+	plugin.StateDir = t.TempDir()
+	if status, err := checkArgs(nil); status != sensu.CheckStateWarning || err == nil {
+		t.Errorf("checkArgs(nil) expected missing check name, status: %v err: %v", status, err)
+	}
+
+	eventFile = writeEventFile(t, `{"entity": {"metadata": {"name": "web-1"}}, "check": {"metadata": {"name": "api-latency"}}}`)
+	defer func() { eventFile = os.Stdin }()
+	plugin = Config{}
+	plugin.Url = "http://localhost"
+	plugin.EvalStatus = 1
+	plugin.StateDir = "/var/cache/sensu/sensu-agent/data-analysis"
+	if _, err := checkArgs(nil); err != nil || plugin.stateFile != "/var/cache/sensu/sensu-agent/data-analysis/api-latency.json" {
+		t.Errorf("checkArgs(nil) state file: %v err: %v", plugin.stateFile, err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+// statsHelpers is preloaded into every eval sandbox. It exposes a "stats"
+// object with functions over either arrays of numbers or arrays of
+// [timestamp, value] pairs, as returned by Prometheus range queries
+// (result.data.result[0].values) and InfluxDB series
+// (result.results[0].series[0].values). Values may be numeric strings, and
+// null or non-numeric values are ignored.
+const statsHelpers = `
+var stats = {
+  // points returns the data as an array of {t: seconds, v: number}.
+  // Plain arrays of numbers use the array index as timestamp.
+  points: function(data) {
+    var points = [];
+    (data || []).forEach(function(item, i) {
+      var t = i, v = item;
+      if (Array.isArray(item)) {
+        t = stats.timestamp(item[0]);
+        v = item[item.length - 1];
+      }
+      if (v === null || v === undefined || v === "") {
+        return;
+      }
+      v = Number(v);
+      if (!isNaN(v)) {
+        points.push({t: t, v: v});
+      }
+    });
+    return points;
+  },
+  // timestamp converts epoch (s, ms, us or ns) numbers and date strings to seconds
+  timestamp: function(t) {
+    if (typeof t === "string" && isNaN(Number(t))) {
+      return Date.parse(t) / 1000;
+    }
+    t = Number(t);
+    if (Math.abs(t) >= 1e17) {
+      return t / 1e9;
+    }
+    if (Math.abs(t) >= 1e14) {
+      return t / 1e6;
+    }
+    if (Math.abs(t) >= 1e11) {
+      return t / 1e3;
+    }
+    return t;
+  },
+  // values returns the numeric values of the data
+  values: function(data) {
+    return stats.points(data).map(function(p) {
+      return p.v;
+    });
+  },
+  count: function(data) {
+    return stats.values(data).length;
+  },
+  sum: function(data) {
+    return stats.values(data).reduce(function(a, b) {
+      return a + b;
+    }, 0);
+  },
+  mean: function(data) {
+    var values = stats.values(data);
+    if (values.length === 0) {
+      return NaN;
+    }
+    return stats.sum(values) / values.length;
+  },
+  min: function(data) {
+    var values = stats.values(data);
+    return values.length === 0 ? NaN : Math.min.apply(null, values);
+  },
+  max: function(data) {
+    var values = stats.values(data);
+    return values.length === 0 ? NaN : Math.max.apply(null, values);
+  },
+  first: function(data) {
+    var values = stats.values(data);
+    return values.length === 0 ? NaN : values[0];
+  },
+  last: function(data) {
+    var values = stats.values(data);
+    return values.length === 0 ? NaN : values[values.length - 1];
+  },
+  // percentile returns the p-th (0-100) percentile, interpolating linearly
+  // between the closest ranks
+  percentile: function(data, p) {
+    if (typeof p !== "number" || p < 0 || p > 100) {
+      throw new RangeError("percentile must be a number between 0 and 100: " + p);
+    }
+    var values = stats.values(data).sort(function(a, b) {
+      return a - b;
+    });
+    if (values.length === 0) {
+      return NaN;
+    }
+    var rank = (p / 100) * (values.length - 1);
+    var lower = Math.floor(rank), upper = Math.ceil(rank);
+    return values[lower] + (values[upper] - values[lower]) * (rank - lower);
+  },
+  median: function(data) {
+    return stats.percentile(data, 50);
+  },
+  // variance returns the population variance
+  variance: function(data) {
+    var values = stats.values(data);
+    if (values.length === 0) {
+      return NaN;
+    }
+    var mean = stats.mean(values);
+    return values.reduce(function(sum, v) {
+      return sum + (v - mean) * (v - mean);
+    }, 0) / values.length;
+  },
+  // stddev returns the population standard deviation
+  stddev: function(data) {
+    return Math.sqrt(stats.variance(data));
+  },
+  // delta returns the difference between the last and first value
+  delta: function(data) {
+    var points = stats.points(data);
+    if (points.length < 2) {
+      return NaN;
+    }
+    return points[points.length - 1].v - points[0].v;
+  },
+  // rate returns the per-second rate of increase of a counter, accounting
+  // for counter resets
+  rate: function(data) {
+    var points = stats.points(data);
+    if (points.length < 2) {
+      return NaN;
+    }
+    var increase = 0;
+    for (var i = 1; i < points.length; i++) {
+      var change = points[i].v - points[i - 1].v;
+      increase += change < 0 ? points[i].v : change;
+    }
+    var duration = points[points.length - 1].t - points[0].t;
+    return duration > 0 ? increase / duration : NaN;
+  },
+  // slope returns the least-squares linear regression slope, per second
+  slope: function(data) {
+    var points = stats.points(data);
+    if (points.length < 2) {
+      return NaN;
+    }
+    var n = points.length, st = 0, sv = 0, stt = 0, stv = 0;
+    var t0 = points[0].t;
+    points.forEach(function(p) {
+      var t = p.t - t0;
+      st += t;
+      sv += p.v;
+      stt += t * t;
+      stv += t * p.v;
+    });
+    var denominator = n * stt - st * st;
+    return denominator === 0 ? NaN : (n * stv - st * sv) / denominator;
+  }
+};
+`
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsHelpers(t *testing.T) {
+	prometheus := `{"data": {"resultType": "matrix", "result": [{"metric": {"instance": "web-1"}, "values": [
+		[1600000000, "1"], [1600000015, "2"], [1600000030, "3"], [1600000045, "4"], [1600000060, "NaN"], [1600000075, "10"]
+	]}, {"metric": {"instance": "web-2"}, "values": [
+		[1600000000, "100"], [1600000060, "160"], [1600000120, "50"], [1600000180, "120"]
+	]}]}}`
+	influxdb := `{"results": [{"series": [{"name": "disk", "columns": ["time", "used"], "values": [
+		["2020-09-13T12:26:40Z", 50], ["2020-09-13T12:27:40Z", 56], ["2020-09-13T12:28:40Z", null], ["2020-09-13T12:29:40Z", 68]
+	]}]}]}`
+	numbers := `{"values": [2, 4, 4, 4, 5, 5, 7, 9]}`
+	tests := []struct {
+		name         string
+		json_data    string
+		jscript      string
+		expect_error bool
+	}{
+		{"mean", numbers, `stats.mean(result.values) === 5`, false},
+		{"sum count", numbers, `stats.sum(result.values) === 40 && stats.count(result.values) === 8`, false},
+		{"min max", numbers, `stats.min(result.values) === 2 && stats.max(result.values) === 9`, false},
+		{"stddev", numbers, `stats.stddev(result.values) === 2 && stats.variance(result.values) === 4`, false},
+		{"median", numbers, `stats.median(result.values) === 4.5`, false},
+		{"percentile", numbers, `stats.percentile(result.values, 0) === 2 && stats.percentile(result.values, 100) === 9 && Math.abs(stats.percentile(result.values, 95) - 8.3) < 1e-9`, false},
+		{"percentile range", numbers, `stats.percentile(result.values, 101)`, true},
+		{"empty mean", `{"values": []}`, `isNaN(stats.mean(result.values))`, false},
+		{"prometheus matrix values", prometheus, `stats.count(result.data.result[0].values) === 5 && stats.max(result.data.result[0].values) === 10`, false},
+		{"prometheus percentile", prometheus, `stats.percentile(result.data.result[0].values, 95) < 10`, false},
+		{"prometheus first last", prometheus, `stats.first(result.data.result[0].values) === 1 && stats.last(result.data.result[0].values) === 10`, false},
+		{"prometheus delta", prometheus, `stats.delta(result.data.result[0].values) === 9`, false},
+		{"prometheus slope", prometheus, `Math.abs(stats.slope(result.data.result[0].values.slice(0, 4)) - 1 / 15) < 1e-9`, false},
+		{"prometheus counter rate with reset", prometheus, `stats.rate(result.data.result[1].values) === 1`, false},
+		{"influxdb series", influxdb, `stats.count(result.results[0].series[0].values) === 3 && stats.mean(result.results[0].series[0].values) === 58`, false},
+		{"influxdb timestamps", influxdb, `stats.slope(result.results[0].series[0].values) === 0.1`, false},
+		{"epoch milliseconds", `{"values": [[1600000000000, 1], [1600000010000, 2]]}`, `stats.slope(result.values) === 0.1`, false},
+		{"epoch nanoseconds", `{"values": [[1600000000000000000, 1], [1600000010000000000, 2]]}`, `stats.rate(result.values) === 0.1`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := processResponse(tt.json_data, tt.jscript)
+			if tt.expect_error {
+				if err == nil {
+					t.Errorf("processResponse() jscript: %v expected err", tt.jscript)
+				}
+				return
+			}
+			if err != nil || !result {
+				t.Errorf("processResponse() jscript: %v result: %v err: %v", tt.jscript, result, err)
+			}
+		})
+	}
+}
+
+func TestStatsArgs(t *testing.T) {
+	var accept string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accept = r.Header.Get("Accept")
+		fmt.Fprint(w, `{"data": {"resultType": "matrix", "result": [{"metric": {}, "values": [[1600000000, "0.1"], [1600000015, "0.2"], [1600000030, "0.4"]]}]}}`)
+	}))
+	defer server.Close()
+
+	// statements and headers containing commas are single values
+	output, err := runCheck(t,
+		"--url", server.URL,
+		"--header", "Accept: application/json, text/plain",
+		"--eval", "stats.percentile(result.data.result[0].values, 95) < 0.5",
+		"--warning-eval", "stats.percentile(result.data.result[0].values, 50) === 0.2",
+		"--critical-eval", `["mean", "max"].every(f => stats[f](result.data.result[0].values) < 1)`,
+	)
+	if err != nil {
+		t.Fatalf("runCheck() err: %v output: %s", err, output)
+	}
+	if accept != "application/json, text/plain" {
+		t.Errorf("runCheck() Accept header: %q", accept)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+)
+
+// statusSeverity ranks check statuses when graded statements disagree:
+// critical is the most severe, followed by unknown, warning and ok.
+var statusSeverity = map[int]int{
+	sensu.CheckStateOK:       0,
+	sensu.CheckStateWarning:  1,
+	sensu.CheckStateUnknown:  2,
+	sensu.CheckStateCritical: 3,
+}
+
+// severity returns the rank of a check status in statusSeverity. Statuses
+// above 3 rank like unknown.
+func severity(status int) int {
+	if rank, found := statusSeverity[status]; found {
+		return rank
+	}
+	return statusSeverity[sensu.CheckStateUnknown]
+}
+
+// evaluateGraded runs every statement of a graded eval group (--eval-mode
+// status) and returns the most severe status returned, with its message.
+func evaluateGraded(vm evaluator, group evalGroup) (int, string, error) {
+	status, output := sensu.CheckStateOK, ""
+	for _, eval := range group.statements {
+		value, err := runStatement(vm, eval)
+		if err != nil {
+			return sensu.CheckStateCritical, "", err
+		}
+		result, message, err := evalResult(value.Export(), group, eval)
+		if err != nil {
+			return sensu.CheckStateCritical, "", err
+		}
+		if plugin.Debug {
+			fmt.Printf("Eval result: status %d (%s)\n", result, eval.name)
+		}
+		if severity(result) > severity(status) {
+			status, output = result, message
+		}
+	}
+	return status, output, nil
+}
+
+// evalResult converts the value returned by a graded eval statement to a
+// check status and message. Statements may return a status (0-3), an object
+// with a status and an optional message, or a bool like a regular eval
+// statement.
+func evalResult(value interface{}, group evalGroup, eval evalStatement) (int, string, error) {
+	message := ""
+	switch result := value.(type) {
+	case bool:
+		if result {
+			return sensu.CheckStateOK, "", nil
+		}
+		return group.status, fmt.Sprintf("%s eval condition was not met: \"%s\" (%v)", group.label, eval.name, result), nil
+	case map[string]interface{}:
+		if text, found := result["message"]; found && text != nil {
+			message = fmt.Sprintf("%v", text)
+		}
+		value = result["status"]
+	}
+	status, ok := statusCode(value)
+	if !ok {
+		return 0, "", fmt.Errorf("eval statement %q must return a status (0-3), a {status, message} object, or a bool: returned %v", eval.name, value)
+	}
+	if len(message) == 0 && status != sensu.CheckStateOK {
+		message = fmt.Sprintf("%s eval statement returned status %d: \"%s\"", group.label, status, eval.name)
+	}
+	return status, message, nil
+}
+
+// statusCode returns the check status of an exported Javascript number.
+func statusCode(value interface{}) (int, bool) {
+	var number float64
+	switch n := value.(type) {
+	case int64:
+		number = float64(n)
+	case float64:
+		number = n
+	default:
+		return 0, false
+	}
+	if number != math.Trunc(number) || number < sensu.CheckStateOK || number > sensu.CheckStateUnknown {
+		return 0, false
+	}
+	return int(number), true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGradedEval(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"latency": {"p50": 120, "p99": 420}}`)
+	}))
+	defer server.Close()
+	tests := []struct {
+		eval_statements []string
+		critical_evals  []string
+		status          int
+		output          string
+		has_err         bool
+	}{
+		{[]string{`0`}, []string{}, sensu.CheckStateOK, "All eval conditions were met.", false},
+		{[]string{`result.latency.p99 > 400 ? 1 : 0`}, []string{}, sensu.CheckStateWarning, `An eval statement returned status 1: "result.latency.p99 > 400 ? 1 : 0"`, false},
+		{[]string{`({status: 1, message: "p99 " + result.latency.p99 + "ms > 400ms"})`}, []string{}, sensu.CheckStateWarning, "p99 420ms > 400ms", false},
+		{[]string{`({status: 0, message: "fine"})`}, []string{}, sensu.CheckStateOK, "All eval conditions were met.", false},
+		{[]string{`1`, `({status: 2, message: "p99 too high"})`, `3`}, []string{}, sensu.CheckStateCritical, "p99 too high", false},
+		{[]string{`3`, `({status: 1, message: "slow"})`}, []string{}, sensu.CheckStateUnknown, `An eval statement returned status 3: "3"`, false},
+		{[]string{`result.latency.p50 < 200`}, []string{}, sensu.CheckStateOK, "All eval conditions were met.", false},
+		{[]string{`result.latency.p50 > 200`}, []string{}, sensu.CheckStateWarning, `An eval condition was not met: "result.latency.p50 > 200" (false)`, false},
+		{[]string{`({status: 1})`}, []string{`result.latency.p99 < 400`}, sensu.CheckStateCritical, `A critical eval condition was not met: "result.latency.p99 < 400" (false)`, false},
+		{[]string{`4`}, []string{}, sensu.CheckStateCritical, "", true},
+		{[]string{`1.5`}, []string{}, sensu.CheckStateCritical, "", true},
+		{[]string{`({message: "no status"})`}, []string{}, sensu.CheckStateCritical, "", true},
+		{[]string{`"warning"`}, []string{}, sensu.CheckStateCritical, "", true},
+	}
+	for _, tt := range tests {
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.Url = server.URL
+		plugin.EvalStatus = 1
+		plugin.EvalMode = "status"
+		plugin.EvalStatements = tt.eval_statements
+		plugin.CriticalEvals = tt.critical_evals
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			continue
+		}
+		vm, err := newSandbox(`{"latency": {"p50": 120, "p99": 420}}`, nil)
+		if err != nil {
+			t.Errorf("newSandbox() unexpected err: %v", err)
+			continue
+		}
+		status, output, err := evaluate(vm)
+		if status != tt.status || output != tt.output || (err != nil) != tt.has_err {
+			t.Errorf("evaluate() eval statements: %v status: %v output: %q err: %v", tt.eval_statements, status, output, err)
+		}
+		status, err = executeCheck(nil)
+		if status != tt.status || (err != nil) != tt.has_err {
+			t.Errorf("executeCheck(nil) eval statements: %v status: %v err: %v", tt.eval_statements, status, err)
+		}
+	}
+
+	plugin.EvalMode = "grade"
+	if status, err := checkArgs(nil); status != sensu.CheckStateWarning || err == nil {
+		t.Errorf("checkArgs(nil) expected unsupported --eval-mode, status: %v err: %v", status, err)
+	}
+}
+
+func TestGradedArgs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": {"resultType": "matrix", "result": [{"metric": {}, "values": [[1600000000, "0.45"], [1600000015, "0.45"]]}]}}`)
+	}))
+	defer server.Close()
+
+	// the object literal statement of the README, with commas
+	output, err := runCheck(t,
+		"--url", server.URL,
+		"--eval-mode", "status",
+		"--eval", `var p99 = stats.percentile(result.data.result[0].values, 99) * 1000;
+  p99 > 500 ? {status: 2, message: `+"`p99 ${p99}ms > 500ms`"+`} :
+  p99 > 400 ? {status: 1, message: `+"`p99 ${p99}ms > 400ms`"+`} : 0`,
+	)
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != sensu.CheckStateWarning || !strings.Contains(string(output), "p99 450ms > 400ms") {
+		t.Errorf("runCheck() err: %v output: %s", err, output)
+	}
+}
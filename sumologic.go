@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// Initial and maximum delay between search job state requests
+	sumologicPollInterval    = 500 * time.Millisecond
+	sumologicMaxPollInterval = 5 * time.Second
+	// Number of records or messages requested per search job page
+	sumologicPageSize = 10000
+)
+
+// sumologicRange is the time range of search jobs without a from and to,
+// when --range is not set.
+const sumologicRange = 15 * time.Minute
+
+// sumologicError is the error of a failed Sumo Logic API request.
+type sumologicError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type sumologicJob struct {
+	sumologicError
+	Id string `json:"id"`
+}
+
+type sumologicJobState struct {
+	sumologicError
+	State           string   `json:"state"`
+	MessageCount    int      `json:"messageCount"`
+	RecordCount     int      `json:"recordCount"`
+	PendingErrors   []string `json:"pendingErrors"`
+	PendingWarnings []string `json:"pendingWarnings"`
+}
+
+type sumologicPage struct {
+	sumologicError
+	Fields  []map[string]interface{} `json:"fields"`
+	Records []struct {
+		Map map[string]interface{} `json:"map"`
+	} `json:"records"`
+	Messages []struct {
+		Map map[string]interface{} `json:"map"`
+	} `json:"messages"`
+}
+
+// sumologicResult is the result of a search job: the records of aggregate
+// queries, or else the messages.
+type sumologicResult struct {
+	State        string                   `json:"state"`
+	RecordCount  int                      `json:"recordCount"`
+	MessageCount int                      `json:"messageCount"`
+	Warnings     []string                 `json:"warnings"`
+	Fields       []map[string]interface{} `json:"fields"`
+	Records      []map[string]interface{} `json:"records"`
+	Messages     []map[string]interface{} `json:"messages"`
+}
+
+// sumologicQuery runs the query as a Sumo Logic search job: the job is
+// created via the search/jobs endpoint (the request url), polled with
+// backoff until it is done gathering results, and its records (or messages,
+// for queries without aggregates) are returned page by page. The job is
+// always deleted, and jobs that don't finish within --timeout fail.
+func sumologicQuery(request queryRequest) ([]byte, error) {
+	deadline := time.Now().Add(time.Duration(plugin.Timeout) * time.Second)
+	now := request.now
+	if now.IsZero() {
+		now = templateNow()
+	}
+	search, err := sumologicSearch(request.query, now)
+	if err != nil {
+		return nil, err
+	}
+	// search jobs are bound to the session cookies of the create request
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	headers := append([]string{}, request.headers...)
+	if len(plugin.AccessId) > 0 && len(plugin.AccessKey) > 0 {
+		credentials := base64.StdEncoding.EncodeToString([]byte(plugin.AccessId + ":" + plugin.AccessKey))
+		headers = append(headers, "Authorization: Basic "+credentials)
+	}
+
+	body, err := doSessionRequest(jar, request.url, request.method, headers, bytes.NewReader(search))
+	if err != nil {
+		return nil, fmt.Errorf("Could not create Sumo Logic search job: %v", err)
+	}
+	var job sumologicJob
+	if err := json.Unmarshal(body, &job); err != nil || len(job.Code) > 0 || len(job.Id) == 0 {
+		return body, fmt.Errorf("Could not create Sumo Logic search job: %s", sumologicMessage(body, job.sumologicError))
+	}
+	if plugin.Debug {
+		fmt.Printf("Created Sumo Logic search job: %v\n", job.Id)
+	}
+	defer sumologicDeleteJob(jar, request.url, headers, job.Id)
+
+	jobUrl, err := sumologicJobUrl(request.url, job.Id, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var state sumologicJobState
+	wait := sumologicPollInterval
+	for {
+		body, err = doSessionRequest(jar, jobUrl, "GET", headers, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get Sumo Logic search job %v state: %v", job.Id, err)
+		}
+		state = sumologicJobState{}
+		if err := json.Unmarshal(body, &state); err != nil || len(state.Code) > 0 || len(state.State) == 0 {
+			return body, fmt.Errorf("Unexpected Sumo Logic search job %v state: %s", job.Id, sumologicMessage(body, state.sumologicError))
+		}
+		if plugin.Debug {
+			fmt.Printf("Sumo Logic search job %v state: %v\n", job.Id, state.State)
+		}
+		if len(state.PendingErrors) > 0 {
+			return body, fmt.Errorf("Sumo Logic search job %v failed: %v", job.Id, strings.Join(state.PendingErrors, "; "))
+		}
+		if state.State == "CANCELLED" {
+			return body, fmt.Errorf("Sumo Logic search job %v was cancelled", job.Id)
+		}
+		// non-aggregate queries are force paused at the message limit
+		if state.State == "DONE GATHERING RESULTS" || state.State == "FORCE PAUSED" {
+			break
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return nil, fmt.Errorf("Sumo Logic search job %v did not complete within %v seconds", job.Id, plugin.Timeout)
+		}
+		time.Sleep(wait)
+		wait *= 2
+		if wait > sumologicMaxPollInterval {
+			wait = sumologicMaxPollInterval
+		}
+	}
+
+	result := sumologicResult{
+		State:        state.State,
+		RecordCount:  state.RecordCount,
+		MessageCount: state.MessageCount,
+		Warnings:     append([]string{}, state.PendingWarnings...),
+		Fields:       []map[string]interface{}{},
+		Records:      []map[string]interface{}{},
+		Messages:     []map[string]interface{}{},
+	}
+	endpoint, count := "records", state.RecordCount
+	if count == 0 {
+		endpoint, count = "messages", state.MessageCount
+	}
+	for offset := 0; offset < count; offset += sumologicPageSize {
+		pageUrl, err := sumologicJobUrl(request.url, job.Id, endpoint, url.Values{
+			"offset": []string{strconv.Itoa(offset)},
+			"limit":  []string{strconv.Itoa(sumologicPageSize)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		body, err = doSessionRequest(jar, pageUrl, "GET", headers, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get Sumo Logic search job %v %v: %w", job.Id, endpoint, err)
+		}
+		var page sumologicPage
+		if err := json.Unmarshal(body, &page); err != nil || len(page.Code) > 0 {
+			return body, fmt.Errorf("Unexpected Sumo Logic search job %v %v: %s", job.Id, endpoint, sumologicMessage(body, page.sumologicError))
+		}
+		if offset == 0 && page.Fields != nil {
+			result.Fields = page.Fields
+		}
+		for _, record := range page.Records {
+			result.Records = append(result.Records, record.Map)
+		}
+		for _, message := range page.Messages {
+			result.Messages = append(result.Messages, message.Map)
+		}
+		if len(page.Records)+len(page.Messages) == 0 {
+			break
+		}
+	}
+	return json.Marshal(result)
+}
+
+// sumologicSearch returns the search job request of the query, either a
+// request object or the query text. The from and to default to the --range
+// ending now, in UTC.
+func sumologicSearch(query string, now time.Time) ([]byte, error) {
+	search := map[string]interface{}{}
+	if strings.HasPrefix(strings.TrimSpace(query), "{") {
+		if err := json.Unmarshal([]byte(query), &search); err != nil {
+			return nil, fmt.Errorf("Invalid Sumo Logic --query: %v", err)
+		}
+	} else {
+		search["query"] = query
+	}
+	if text, _ := search["query"].(string); len(strings.TrimSpace(text)) == 0 {
+		return nil, fmt.Errorf("Invalid Sumo Logic --query: a search query is required")
+	}
+
+	window := sumologicRange
+	if d, err := parseDuration(plugin.Range); err == nil {
+		window = d
+	}
+	if _, found := search["from"]; !found {
+		search["from"] = now.Add(-window).UTC().Format("2006-01-02T15:04:05")
+	}
+	if _, found := search["to"]; !found {
+		search["to"] = now.UTC().Format("2006-01-02T15:04:05")
+	}
+	if _, found := search["timeZone"]; !found {
+		search["timeZone"] = "UTC"
+	}
+	return json.Marshal(search)
+}
+
+// sumologicDeleteJob deletes the search job, so it doesn't count against
+// the concurrent search job limit. Errors are only reported since the check
+// result doesn't depend on it.
+func sumologicDeleteJob(jar http.CookieJar, urlString string, headers []string, id string) {
+	jobUrl, err := sumologicJobUrl(urlString, id, "", nil)
+	if err == nil {
+		_, err = doSessionRequest(jar, jobUrl, "DELETE", headers, nil)
+	}
+	if err != nil && plugin.Verbose {
+		fmt.Printf("Could not delete Sumo Logic search job %v: %v\n", id, err)
+	}
+}
+
+// sumologicJobUrl returns the url of the search job id (or one of its
+// endpoints) below the search/jobs url, with the params added to its query
+// parameters.
+func sumologicJobUrl(urlString string, id string, endpoint string, params url.Values) (string, error) {
+	jobUrl, err := url.Parse(urlString)
+	if err != nil {
+		return "", err
+	}
+	jobUrl.Path = fmt.Sprintf("%v/%v", strings.TrimSuffix(jobUrl.Path, "/"), url.PathEscape(id))
+	if len(endpoint) > 0 {
+		jobUrl.Path = fmt.Sprintf("%v/%v", jobUrl.Path, endpoint)
+	}
+	if len(params) > 0 {
+		query := jobUrl.Query()
+		for name, values := range params {
+			query[name] = values
+		}
+		jobUrl.RawQuery = query.Encode()
+	}
+	return jobUrl.String(), nil
+}
+
+// sumologicMessage returns the code and message of an API error, or else
+// the response body.
+func sumologicMessage(body []byte, apiError sumologicError) string {
+	if len(apiError.Code) > 0 {
+		return fmt.Sprintf("%v: %v", apiError.Code, apiError.Message)
+	}
+	return strings.TrimSpace(string(body))
+}
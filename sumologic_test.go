@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sumoApi is the state of the search job of a Sumo Logic stub. Jobs are
+// bound to the session cookie of the create request, and their records and
+// messages are served in pages.
+type sumoApi struct {
+	polls     int
+	doneAfter int
+	state     string
+	errors    []string
+	records   int
+	messages  int
+	pages     int
+	deleted   bool
+	search    map[string]interface{}
+}
+
+func (s *sumoApi) api() *stubApi {
+	return &stubApi{
+		before: func(w http.ResponseWriter, r *http.Request) bool {
+			if id, key, ok := r.BasicAuth(); !ok || id != "suABC123" || key != "sumo-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"status": 401, "id": "7PAEF-J6JQB-U4NWN", "code": "unauthorized", "message": "Credential could not be verified."}`)
+				return false
+			}
+			if r.Method == "POST" && r.URL.Path == "/api/v1/search/jobs" {
+				return true
+			}
+			if cookie, err := r.Cookie("JSESSIONID"); err != nil || cookie.Value != "session-1" {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `{"status": 404, "id": "IUUQI-DJC6W-7ZQ7F", "code": "searchjob.jobid.invalid", "message": "Job ID is invalid."}`)
+				return false
+			}
+			return true
+		},
+		routes: map[string]http.HandlerFunc{
+			"POST /api/v1/search/jobs": func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				json.Unmarshal(body, &s.search)
+				http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "session-1", Path: "/"})
+				w.WriteHeader(http.StatusAccepted)
+				fmt.Fprint(w, `{"id": "4A6B7C8D", "link": {"rel": "self", "href": "https://api.sumologic.com/api/v1/search/jobs/4A6B7C8D"}}`)
+			},
+			"GET /api/v1/search/jobs/4A6B7C8D": func(w http.ResponseWriter, r *http.Request) {
+				s.polls++
+				state := "GATHERING RESULTS"
+				if len(s.state) > 0 {
+					state = s.state
+				} else if s.doneAfter > 0 && s.polls >= s.doneAfter {
+					state = "DONE GATHERING RESULTS"
+				}
+				errors, _ := json.Marshal(append([]string{}, s.errors...))
+				fmt.Fprintf(w, `{"state": %q, "messageCount": %d, "recordCount": %d, "pendingErrors": %s, "pendingWarnings": ["slow query"], "histogramBuckets": []}`,
+					state, s.messages, s.records, errors)
+			},
+			"GET /api/v1/search/jobs/4A6B7C8D/records": func(w http.ResponseWriter, r *http.Request) {
+				offset, limit, ok := sumoPage(w, r)
+				if !ok {
+					return
+				}
+				s.pages++
+				records := []string{}
+				for i := offset; i < offset+limit && i < s.records; i++ {
+					records = append(records, fmt.Sprintf(`{"map": {"_sourcehost": "web-%d", "count": "%d"}}`, i+1, i+1))
+				}
+				fmt.Fprintf(w, `{"fields": [{"name": "_sourcehost", "fieldType": "string", "keyField": true}, {"name": "count", "fieldType": "int", "keyField": false}], "records": [%s]}`, strings.Join(records, ","))
+			},
+			"GET /api/v1/search/jobs/4A6B7C8D/messages": func(w http.ResponseWriter, r *http.Request) {
+				offset, limit, ok := sumoPage(w, r)
+				if !ok {
+					return
+				}
+				s.pages++
+				messages := []string{}
+				for i := offset; i < offset+limit && i < s.messages; i++ {
+					messages = append(messages, fmt.Sprintf(`{"map": {"_messagetime": "%d", "_raw": "error %d"}}`, 1600000000000+int64(i)*1000, i+1))
+				}
+				fmt.Fprintf(w, `{"fields": [{"name": "_raw", "fieldType": "string", "keyField": false}], "messages": [%s]}`, strings.Join(messages, ","))
+			},
+			"DELETE /api/v1/search/jobs/4A6B7C8D": func(w http.ResponseWriter, r *http.Request) {
+				s.deleted = true
+				fmt.Fprint(w, `{"id": "4A6B7C8D"}`)
+			},
+		},
+	}
+}
+
+// sumoPage returns the offset and limit of a records or messages request,
+// responding 404 if the limit is missing.
+func sumoPage(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return 0, 0, false
+	}
+	return offset, limit, true
+}
+
+func TestSumologicQuery(t *testing.T) {
+	sumologicPollInterval = 10 * time.Millisecond
+	sumologicPageSize = 2
+	defer func() {
+		sumologicPageSize = 10000
+	}()
+	tests := []struct {
+		name           string
+		query          string
+		access_key     string
+		done_after     int
+		state          string
+		errors         []string
+		records        int
+		messages       int
+		eval           string
+		expected_pages int
+		expected_from  string
+		expect_error   bool
+		expect_deleted bool
+	}{
+		{
+			name:           "paginated records",
+			query:          `_sourceCategory=prod/web error | count by _sourcehost`,
+			done_after:     3,
+			records:        5,
+			messages:       40,
+			eval:           `result.records.length === 5 && result.records[4]._sourcehost === "web-5" && stats.sum(result.records.map(r => r.count)) === 15 && result.fields[1].name === "count" && result.messages.length === 0 && result.warnings[0] === "slow query"`,
+			expected_pages: 3,
+			expected_from:  "2020-09-13T12:11:40",
+			expect_deleted: true,
+		},
+		{
+			name:           "messages of non-aggregate queries",
+			query:          `{"query": "_sourceCategory=prod/web error", "from": "2020-09-13T11:00:00", "to": "2020-09-13T12:00:00"}`,
+			state:          "FORCE PAUSED",
+			messages:       3,
+			eval:           `result.messages.length === 3 && result.messages[2]._raw === "error 3" && result.records.length === 0 && result.state === "FORCE PAUSED"`,
+			expected_pages: 2,
+			expected_from:  "2020-09-13T11:00:00",
+			expect_deleted: true,
+		},
+		{
+			name:           "no results",
+			query:          `_sourceCategory=prod/web error | count`,
+			done_after:     1,
+			eval:           `result.recordCount === 0 && result.records.length === 0 && result.messages.length === 0`,
+			expected_from:  "2020-09-13T12:11:40",
+			expect_deleted: true,
+		},
+		{
+			name:           "job errors",
+			query:          `_sourceCategory=prod/web | parse`,
+			errors:         []string{"Parse error: unexpected end of query"},
+			expected_from:  "2020-09-13T12:11:40",
+			expect_error:   true,
+			expect_deleted: true,
+		},
+		{
+			name:           "job cancelled",
+			query:          `_sourceCategory=prod/web error`,
+			state:          "CANCELLED",
+			expected_from:  "2020-09-13T12:11:40",
+			expect_error:   true,
+			expect_deleted: true,
+		},
+		{
+			name:           "job times out",
+			query:          `_sourceCategory=prod/web error`,
+			expected_from:  "2020-09-13T12:11:40",
+			expect_error:   true,
+			expect_deleted: true,
+		},
+		{
+			name:         "invalid credentials",
+			query:        `_sourceCategory=prod/web error`,
+			access_key:   "invalid",
+			expect_error: true,
+		},
+		{
+			name:         "missing query",
+			query:        `{"from": "2020-09-13T11:00:00"}`,
+			expect_error: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &sumoApi{doneAfter: tt.done_after, state: tt.state, errors: tt.errors, records: tt.records, messages: tt.messages}
+			serverUrl := startStub(t, "sumologic", stub.api())
+			plugin.now = time.Unix(1600000000, 0)
+			plugin.Timeout = 1
+			plugin.AccessId = "suABC123"
+			plugin.AccessKey = "sumo-key"
+			if len(tt.access_key) > 0 {
+				plugin.AccessKey = tt.access_key
+			}
+			plugin.Url = serverUrl.String() + "/api/v1/search/jobs"
+			plugin.Request = "POST"
+			plugin.Headers = supportedServices["sumologic"].Headers
+			response, err := runQuery(plugin.Url, plugin.Request, tt.query)
+			if tt.expect_error != (err != nil) {
+				t.Errorf("runQuery() expect_error: %v, err: %v\n", tt.expect_error, err)
+				return
+			}
+			if stub.deleted != tt.expect_deleted {
+				t.Errorf("runQuery() deleted: %v, expected: %v\n", stub.deleted, tt.expect_deleted)
+			}
+			if len(tt.expected_from) > 0 && (stub.search["from"] != tt.expected_from || stub.search["timeZone"] != "UTC") {
+				t.Errorf("runQuery() unexpected search job: %v\n", stub.search)
+			}
+			if tt.expect_error {
+				return
+			}
+			if stub.pages != tt.expected_pages {
+				t.Errorf("runQuery() pages: %v, expected: %v\n", stub.pages, tt.expected_pages)
+			}
+			if result, err := processResponse(string(response), tt.eval); !result || err != nil {
+				t.Errorf("processResponse() response: %s, result: %v, err: %v\n", response, result, err)
+			}
+		})
+	}
+}
+
+func TestSumologicUrl(t *testing.T) {
+	plugin = Config{
+		PluginConfig: sensu.PluginConfig{
+			Name:  "test",
+			Short: "test",
+		},
+	}
+	plugin.Type = "sumologic"
+	plugin.Host = "api.eu.sumologic.com"
+	url, err := finalUrl()
+	if err != nil || url != `https://api.eu.sumologic.com:443/api/v1/search/jobs` {
+		t.Errorf("finalUrl() url: %v, err: %v\n", url, err)
+	}
+}
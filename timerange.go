@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// macroTime is a time returned by the time macros, rendered as epoch seconds
+// unless formatted by another macro (e.g. rfc3339).
+type macroTime time.Time
+
+func (t macroTime) String() string {
+	return strconv.FormatInt(time.Time(t).Unix(), 10)
+}
+
+// parseDuration parses a duration such as "90s", "15m", "1h30m", or with the
+// day and week units used by Prometheus and InfluxDB, "7d" or "2w".
+func parseDuration(text string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	for unit, size := range units {
+		if strings.HasSuffix(text, unit) {
+			count, err := strconv.ParseFloat(strings.TrimSuffix(text, unit), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", text)
+			}
+			return time.Duration(count * float64(size)), nil
+		}
+	}
+	return time.ParseDuration(text)
+}
+
+// templateNow returns the reference time of the time macros, so that all
+// templates of a check are rendered against the same time.
+func templateNow() time.Time {
+	if plugin.now.IsZero() {
+		plugin.now = time.Now()
+	}
+	return plugin.now
+}
+
+// templateFuncs returns the time macros available to templates: now, start
+// (the beginning of the --range, which ends now), ago "15m", step (the --step
+// duration), and the rfc3339, unix, unixms and unixns time formats.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"now": func() macroTime {
+			return macroTime(templateNow())
+		},
+		"ago": func(duration string) (macroTime, error) {
+			d, err := parseDuration(duration)
+			if err != nil {
+				return macroTime{}, err
+			}
+			return macroTime(templateNow().Add(-d)), nil
+		},
+		"start": func() (macroTime, error) {
+			d, err := parseDuration(plugin.Range)
+			if err != nil {
+				return macroTime{}, fmt.Errorf("--range: %v", err)
+			}
+			return macroTime(templateNow().Add(-d)), nil
+		},
+		"step": func() string {
+			return plugin.Step
+		},
+		"rfc3339": func(t macroTime) string {
+			return time.Time(t).UTC().Format(time.RFC3339)
+		},
+		"unix": func(t macroTime) int64 {
+			return time.Time(t).Unix()
+		},
+		"unixms": func(t macroTime) int64 {
+			return time.Time(t).UnixNano() / int64(time.Millisecond)
+		},
+		"unixns": func(t macroTime) int64 {
+			return time.Time(t).UnixNano()
+		},
+	}
+}
+
+// timeParams renders the range query params of the service type when a
+// --range is set, or its instant query params when a --baseline-offset is set.
+func timeParams(service ServiceType) (string, error) {
+	params := ""
+	if len(plugin.Range) > 0 {
+		params = service.RangeParams
+	} else if len(plugin.BaselineOffset) > 0 {
+		params = service.InstantParams
+	}
+	return renderTemplate("params", params, plugin.event)
+}
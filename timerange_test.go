@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		expected time.Duration
+		has_err  bool
+	}{
+		{"90s", 90 * time.Second, false},
+		{"15m", 15 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"", 0, true},
+		{"xd", 0, true},
+		{"15", 0, true},
+	}
+	for _, tt := range tests {
+		duration, err := parseDuration(tt.duration)
+		if duration != tt.expected || (err != nil) != tt.has_err {
+			t.Errorf("parseDuration(%q) duration: %v err: %v", tt.duration, duration, err)
+		}
+	}
+}
+
+func TestTimeMacros(t *testing.T) {
+	tests := []struct {
+		template string
+		expected string
+		has_err  bool
+	}{
+		{`{{ now }}`, "1600000000", false},
+		{`{{ ago "15m" }}`, "1599999100", false},
+		{`{{ rfc3339 (ago "1h") }}`, "2020-09-13T11:26:40Z", false},
+		{`{{ ago "1d" | rfc3339 }}`, "2020-09-12T12:26:40Z", false},
+		{`{{ unixms now }}`, "1600000000000", false},
+		{`{{ unixns (ago "1s") }}`, "1599999999000000000", false},
+		{`SELECT mean("value") FROM "cpu" WHERE time > {{ unix start }}s GROUP BY time({{ step }})`, `SELECT mean("value") FROM "cpu" WHERE time > 1599996400s GROUP BY time(5m)`, false},
+		{`start={{ start }}&end={{ now }}`, "start=1599996400&end=1600000000", false},
+		{`{{ ago "15" }}`, "", true},
+		{`{{ rfc3339 "2020-09-13" }}`, "", true},
+	}
+	for _, tt := range tests {
+		plugin = Config{}
+		plugin.now = time.Unix(1600000000, 0)
+		plugin.Range = "1h"
+		plugin.Step = "5m"
+		rendered, err := renderTemplate("test", tt.template, nil)
+		if rendered != tt.expected || (err != nil) != tt.has_err {
+			t.Errorf("renderTemplate(%q) rendered: %q err: %v", tt.template, rendered, err)
+		}
+	}
+}
+
+func TestRangeQuery(t *testing.T) {
+	tests := []struct {
+		service_type string
+		range_       string
+		step         string
+		params       string
+		expected     string
+	}{
+		{"prometheus", "", "1m", "", "http://localhost:9090/api/v1/query?query=up"},
+		{"prometheus", "1h", "1m", "", "http://localhost:9090/api/v1/query_range?query=up&start=1599996400&end=1600000000&step=1m"},
+		{"prometheus", "1d", "15m", "query=node_load1", "http://localhost:9090/api/v1/query_range?query=node_load1&start=1599913600&end=1600000000&step=15m"},
+		{"influxdb", "1h", "1m", "", "http://localhost:8086/query?db=sensu&epoch=s"},
+		{"loki", "", "1m", "", "http://localhost:3100/loki/api/v1/query"},
+		{"loki", "1h", "1m", "", "http://localhost:3100/loki/api/v1/query_range?start=1599996400000000000&end=1600000000000000000&step=1m"},
+		{"elasticsearch", "1h", "1m", "", "http://localhost:9200/_search"},
+	}
+	for _, tt := range tests {
+		plugin = Config{
+			PluginConfig: sensu.PluginConfig{
+				Name:  "test",
+				Short: "test",
+			},
+		}
+		plugin.now = time.Unix(1600000000, 0)
+		plugin.Type = tt.service_type
+		plugin.Range = tt.range_
+		plugin.Step = tt.step
+		plugin.ApiParams = tt.params
+		plugin.EvalStatus = 1
+		if _, err := checkArgs(nil); err != nil {
+			t.Errorf("checkArgs(nil) unexpected err: %v", err)
+			continue
+		}
+		if plugin.Url != tt.expected {
+			t.Errorf("checkArgs(nil) url: %v expected: %v", plugin.Url, tt.expected)
+		}
+	}
+
+	for _, invalid := range []struct{ range_, step string }{{"1x", "1m"}, {"1h", "0s"}, {"-1h", "1m"}} {
+		plugin = Config{}
+		plugin.Type = "prometheus"
+		plugin.Range = invalid.range_
+		plugin.Step = invalid.step
+		plugin.EvalStatus = 1
+		if status, err := checkArgs(nil); status != sensu.CheckStateWarning || err == nil {
+			t.Errorf("checkArgs(nil) range: %v step: %v status: %v err: %v", invalid.range_, invalid.step, status, err)
+		}
+	}
+}